@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Access metrics
+	user_enabled = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "user_enabled"),
+		"Indicates if a user is enabled.",
+		[]string{"userid"}, nil,
+	)
+	user_expiry_timestamp_seconds = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "user_expiry_timestamp_seconds"),
+		"The expiry date (unix seconds) of a user, not exported for users which don't expire.",
+		[]string{"userid"}, nil,
+	)
+	token_enabled = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "token_enabled"),
+		"Indicates if an API token is enabled.",
+		[]string{"userid", "tokenid"}, nil,
+	)
+	token_expiry_timestamp_seconds = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "token_expiry_timestamp_seconds"),
+		"The expiry date (unix seconds) of an API token, not exported for tokens which don't expire.",
+		[]string{"userid", "tokenid"}, nil,
+	)
+	own_token_expiry_timestamp_seconds = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "own_token_expiry_timestamp_seconds"),
+		"The expiry date (unix seconds) of the API token of the exporter or its user, whichever expires first.",
+		[]string{"tokenid"}, nil,
+	)
+)
+
+const accessUserApi = "/api2/json/access/users"
+
+// UserResponse is the user list including the API tokens. Only the ids, the enabled state and
+// the expiry are decoded, an expiry of 0 means never.
+type UserResponse struct {
+	Data []struct {
+		UserID string `json:"userid"`
+		Enable *bool  `json:"enable"`
+		Expire int64  `json:"expire"`
+		Tokens []struct {
+			TokenID string `json:"tokenid"`
+			Enable  *bool  `json:"enable"`
+			Expire  int64  `json:"expire"`
+		} `json:"tokens"`
+	} `json:"data"`
+}
+
+func (e *Exporter) getAccessMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	// users without Sys.Audit on /access/users only get their own user
+	var response UserResponse
+	err := e.getJSON(ctx, accessUserApi+"?include_tokens=1", &response)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range response.Data {
+		ch <- prometheus.MustNewConstMetric(
+			user_enabled, prometheus.GaugeValue, enabledValue(user.Enable), user.UserID,
+		)
+		if user.Expire > 0 {
+			ch <- prometheus.MustNewConstMetric(
+				user_expiry_timestamp_seconds, prometheus.GaugeValue, float64(user.Expire), user.UserID,
+			)
+		}
+
+		for _, token := range user.Tokens {
+			ch <- prometheus.MustNewConstMetric(
+				token_enabled, prometheus.GaugeValue, enabledValue(token.Enable), user.UserID, token.TokenID,
+			)
+			if token.Expire > 0 {
+				ch <- prometheus.MustNewConstMetric(
+					token_expiry_timestamp_seconds, prometheus.GaugeValue, float64(token.Expire), user.UserID, token.TokenID,
+				)
+			}
+
+			if token.TokenID != e.tokenID {
+				continue
+			}
+			// the token can't be used once its user expired
+			expire := token.Expire
+			if user.Expire > 0 && (expire == 0 || user.Expire < expire) {
+				expire = user.Expire
+			}
+			e.logger.Debug("Own API token", "tokenid", token.TokenID, "expire", expire)
+			if expire > 0 {
+				ch <- prometheus.MustNewConstMetric(
+					own_token_expiry_timestamp_seconds, prometheus.GaugeValue, float64(expire), token.TokenID,
+				)
+			}
+		}
+	}
+
+	return nil
+}
+
+// enabledValue maps the enable flag of a user or token to 1/0, a missing flag means enabled.
+func enabledValue(enable *bool) float64 {
+	if enable != nil && !*enable {
+		return 0.0
+	}
+	return 1.0
+}
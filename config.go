@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// collectorNames are the collectors which can be enabled per target, in the order they are
+// documented.
+var collectorNames = []string{"version", "remotes", "remote_probe", "datastore", "snapshot", "gc", "node", "updates", "services", "subscription", "certificates", "jobs", "tasks", "disks", "zfs", "tape", "access"}
+
+// defaultCollectors are the collectors enabled by default, the others query expensive or
+// privileged APIs.
+var defaultCollectors = []string{"version", "remotes", "datastore", "snapshot", "gc", "node", "services", "subscription", "certificates", "jobs", "tasks"}
+
+var (
+	// flagTarget holds the connection settings of the flags without the secret files, set in main
+	flagTarget TargetConfig
+
+	// flagSettings holds the settings of the flags which are not per target, set in main
+	flagSettings Settings
+
+	// currentConfig is the config in use, it is replaced as a whole on reload
+	currentConfig atomic.Pointer[Config]
+)
+
+// Secret is a string which is redacted when it is printed, logged or marshaled.
+type Secret string
+
+const redacted = "<redacted>"
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+func (s Secret) MarshalYAML() (any, error) {
+	return s.String(), nil
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// Config is the file passed with --config.file.
+type Config struct {
+	// Modules are selected with ?module=, the endpoint is taken from ?target= unless the
+	// module sets one.
+	Modules map[string]*TargetConfig `yaml:"modules"`
+	// Targets are selected with ?target=<name> and always use their own endpoint.
+	Targets map[string]*TargetConfig `yaml:"targets"`
+	// AllowedTargets limits the endpoints which can be passed with ?target=, all endpoints
+	// are allowed if it is not set.
+	AllowedTargets *TargetAllowList `yaml:"allowed_targets"`
+	// RestrictCredentials rejects all endpoints passed with ?target=, so the credentials are
+	// only sent to the endpoints of the config file and the pbs.endpoint flag.
+	RestrictCredentials bool `yaml:"restrict_credentials"`
+
+	// defaults are the settings of the flags and secret files
+	defaults *TargetConfig
+}
+
+// Settings are the effective settings of the flags which apply to all targets, they are part of
+// the config dump.
+type Settings struct {
+	Endpoint        string        `yaml:"endpoint"`
+	ListenAddress   string        `yaml:"listen_address"`
+	Concurrency     int           `yaml:"concurrency"`
+	ScrapeTimeout   time.Duration `yaml:"scrape_timeout"`
+	PollInterval    time.Duration `yaml:"poll_interval"`
+	LegacyVMMetrics bool          `yaml:"legacy_vm_metrics"`
+	SnapshotDetails bool          `yaml:"snapshot_details"`
+}
+
+// TargetConfig holds the connection settings of a Proxmox Backup Server. Empty fields are
+// taken from the flags.
+type TargetConfig struct {
+	Endpoint     string        `yaml:"endpoint"`
+	Username     string        `yaml:"username"`
+	APITokenName string        `yaml:"api_token_name"`
+	APIToken     Secret        `yaml:"api_token"`
+	APITokenFile string        `yaml:"api_token_file"`
+	Timeout      time.Duration `yaml:"timeout"`
+	TLSConfig    TLSConfig     `yaml:"tls_config"`
+	Collectors   []string      `yaml:"collectors"`
+
+	client *http.Client
+}
+
+// TLSConfig configures the verification of the PBS certificate and the client certificate.
+type TLSConfig struct {
+	InsecureSkipVerify *bool `yaml:"insecure_skip_verify"`
+	// CAFile is a PEM bundle of the CAs trusted in addition to the system CAs
+	CAFile string `yaml:"ca_file"`
+	// Fingerprint is the SHA-256 fingerprint of the PBS certificate, as shown by
+	// `proxmox-backup-manager cert info`. The certificate is only accepted if it matches.
+	Fingerprint string `yaml:"fingerprint"`
+	CertFile    string `yaml:"cert_file"`
+	KeyFile     string `yaml:"key_file"`
+}
+
+// TargetAllowList matches the host of an endpoint against exact host names (with or without
+// port), CIDRs and regular expressions. Host names are not resolved for the CIDRs.
+type TargetAllowList struct {
+	Hosts   []string `yaml:"hosts"`
+	CIDRs   []string `yaml:"cidrs"`
+	Regexes []string `yaml:"regexes"`
+
+	prefixes []netip.Prefix
+	regexes  []*regexp.Regexp
+}
+
+// loadConfig reads the secret files and the config file and validates them. Missing settings
+// of the modules and targets are taken from the flags. An empty filename only loads the flags.
+func loadConfig(filename string) (*Config, error) {
+	defaults, err := loadDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{defaults: defaults}
+	if filename == "" {
+		return cfg, nil
+	}
+
+	content, err := os.ReadFile(filepath.Clean(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", filename, err)
+	}
+
+	if cfg.AllowedTargets != nil {
+		if err := cfg.AllowedTargets.compile(); err != nil {
+			return nil, fmt.Errorf("allowed_targets: %w", err)
+		}
+	}
+
+	for name, module := range cfg.Modules {
+		if err := module.complete(defaults); err != nil {
+			return nil, fmt.Errorf("module %q: %w", name, err)
+		}
+	}
+	for name, target := range cfg.Targets {
+		if target.Endpoint == "" {
+			return nil, fmt.Errorf("target %q: endpoint is required", name)
+		}
+		if err := target.complete(defaults); err != nil {
+			return nil, fmt.Errorf("target %q: %w", name, err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// loadDefaults returns the settings of the flags with the secrets of the PBS_*_FILE environment
+// variables. The files are read again on every call, so rotated secrets are picked up on reload.
+func loadDefaults() (*TargetConfig, error) {
+	defaults := flagTarget
+	for _, secret := range []struct {
+		env string
+		set func(string)
+	}{
+		{"PBS_USERNAME", func(v string) { defaults.Username = v }},
+		{"PBS_API_TOKEN_NAME", func(v string) { defaults.APITokenName = v }},
+		{"PBS_API_TOKEN", func(v string) { defaults.APIToken = Secret(v) }},
+	} {
+		// the environment variable takes precedence over the secret file
+		filename := os.Getenv(secret.env + "_FILE")
+		if os.Getenv(secret.env) != "" || filename == "" {
+			continue
+		}
+		value, err := readSecret(filename)
+		if err != nil {
+			return nil, err
+		}
+		secret.set(value)
+	}
+
+	if err := defaults.complete(&defaults); err != nil {
+		return nil, err
+	}
+	return &defaults, nil
+}
+
+// complete fills the missing settings from defaults, validates the collectors and builds the
+// http client of the target.
+func (t *TargetConfig) complete(defaults *TargetConfig) error {
+	if t == nil {
+		return fmt.Errorf("empty configuration")
+	}
+	if t.Username == "" {
+		t.Username = defaults.Username
+	}
+	if t.APITokenName == "" {
+		t.APITokenName = defaults.APITokenName
+	}
+	if t.APITokenFile != "" {
+		token, err := readSecret(t.APITokenFile)
+		if err != nil {
+			return err
+		}
+		t.APIToken = Secret(token)
+	}
+	if t.APIToken == "" {
+		t.APIToken = defaults.APIToken
+	}
+	if t.Timeout == 0 {
+		t.Timeout = defaults.Timeout
+	}
+	if t.TLSConfig.InsecureSkipVerify == nil {
+		t.TLSConfig.InsecureSkipVerify = defaults.TLSConfig.InsecureSkipVerify
+	}
+	if t.TLSConfig.CAFile == "" {
+		t.TLSConfig.CAFile = defaults.TLSConfig.CAFile
+	}
+	if t.TLSConfig.Fingerprint == "" {
+		t.TLSConfig.Fingerprint = defaults.TLSConfig.Fingerprint
+	}
+	if t.TLSConfig.CertFile == "" && t.TLSConfig.KeyFile == "" {
+		t.TLSConfig.CertFile = defaults.TLSConfig.CertFile
+		t.TLSConfig.KeyFile = defaults.TLSConfig.KeyFile
+	}
+	if t.Collectors == nil {
+		t.Collectors = defaults.Collectors
+	}
+	for _, c := range t.Collectors {
+		if !slices.Contains(collectorNames, c) {
+			return fmt.Errorf("unknown collector %q, valid collectors are %s", c, strings.Join(collectorNames, ", "))
+		}
+	}
+
+	tlsConfig, err := t.TLSConfig.newTLSConfig()
+	if err != nil {
+		return fmt.Errorf("tls_config: %w", err)
+	}
+	t.client = &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+			// connections of clients replaced by a reload are closed eventually
+			IdleConnTimeout: 90 * time.Second,
+		},
+		Timeout: t.Timeout,
+	}
+	return nil
+}
+
+// closeIdleConnections closes the idle connections of the http clients of the config, e.g. after
+// it was replaced by a reload.
+func (c *Config) closeIdleConnections() {
+	targets := []*TargetConfig{c.defaults}
+	for _, t := range c.Modules {
+		targets = append(targets, t)
+	}
+	for _, t := range c.Targets {
+		targets = append(targets, t)
+	}
+	for _, t := range targets {
+		if t != nil && t.client != nil {
+			t.client.CloseIdleConnections()
+		}
+	}
+}
+
+// newTLSConfig reads the CA and client certificate files, so they are read again on reload.
+func (c *TLSConfig) newTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify != nil && *c.InsecureSkipVerify, // #nosec G402 -- opt-in via pbs.insecure or insecure_skip_verify
+	}
+
+	if c.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(filepath.Clean(c.CAFile))
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, fmt.Errorf("cert_file and key_file must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.Fingerprint != "" {
+		fingerprint, err := hex.DecodeString(strings.ReplaceAll(c.Fingerprint, ":", ""))
+		if err != nil || len(fingerprint) != sha256.Size {
+			return nil, fmt.Errorf("invalid SHA-256 fingerprint %q", c.Fingerprint)
+		}
+		// the pinned certificate replaces the verification of the chain, like the Proxmox clients do
+		tlsConfig.InsecureSkipVerify = true // #nosec G402 -- the certificate is verified by its fingerprint
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("no server certificate")
+			}
+			sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+			if !bytes.Equal(sum[:], fingerprint) {
+				return fmt.Errorf("server certificate fingerprint %s does not match %s", formatFingerprint(sum[:]), c.Fingerprint)
+			}
+			return nil
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// formatFingerprint formats a fingerprint like PBS, as colon separated hex bytes.
+func formatFingerprint(fingerprint []byte) string {
+	parts := make([]string, len(fingerprint))
+	for i, b := range fingerprint {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// resolve returns the endpoint and settings for the module and target query parameters of
+// a scrape, and the key identifying it between scrapes.
+func (c *Config) resolve(defaultEndpoint string, module string, target string) (string, *TargetConfig, string, error) {
+	if module != "" {
+		if c.Modules[module] == nil {
+			return "", nil, "", fmt.Errorf("unknown module %q", module)
+		}
+		cfg := c.Modules[module]
+		endpoint := cfg.Endpoint
+		if endpoint == "" {
+			if target == "" {
+				return "", nil, "", fmt.Errorf("module %q has no endpoint and no target was given", module)
+			}
+			if err := c.checkTarget(target); err != nil {
+				return "", nil, "", err
+			}
+			endpoint = normalizeEndpoint(target)
+		}
+		return endpoint, cfg, module + "@" + endpoint, nil
+	}
+
+	if c.Targets[target] != nil {
+		cfg := c.Targets[target]
+		return cfg.Endpoint, cfg, target + "@" + cfg.Endpoint, nil
+	}
+
+	// if endpoint was not set as flag or env variable, we try to get it from "target" query parameter
+	endpoint := defaultEndpoint
+	if endpoint == "" && target == "" {
+		// if target is not set, we use the default
+		endpoint = "http://localhost:8007"
+	} else if endpoint == "" {
+		if err := c.checkTarget(target); err != nil {
+			return "", nil, "", err
+		}
+		endpoint = normalizeEndpoint(target)
+	}
+	return endpoint, c.defaults, endpoint, nil
+}
+
+// checkTarget returns an error if the credentials must not be sent to an endpoint passed
+// with ?target=.
+func (c *Config) checkTarget(target string) error {
+	if c.RestrictCredentials {
+		return fmt.Errorf("target %q is not configured", target)
+	}
+	if c.AllowedTargets != nil && !c.AllowedTargets.allows(target) {
+		return fmt.Errorf("target %q is not allowed", target)
+	}
+	return nil
+}
+
+func (l *TargetAllowList) compile() error {
+	for _, cidr := range l.CIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return err
+		}
+		l.prefixes = append(l.prefixes, prefix)
+	}
+	for _, expr := range l.Regexes {
+		// anchored like the regular expressions of Prometheus
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return err
+		}
+		l.regexes = append(l.regexes, re)
+	}
+	return nil
+}
+
+// allows returns true if the host of the endpoint matches an entry of the allow-list. Only
+// http and https endpoints are allowed.
+func (l *TargetAllowList) allows(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return false
+	}
+	host := u.Hostname()
+
+	for _, h := range l.Hosts {
+		if strings.EqualFold(h, host) || strings.EqualFold(h, u.Host) {
+			return true
+		}
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		for _, prefix := range l.prefixes {
+			if prefix.Contains(addr.Unmap()) {
+				return true
+			}
+		}
+	}
+	for _, re := range l.regexes {
+		if re.MatchString(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeEndpoint returns the endpoint with lower case scheme and host, without default port,
+// user info, query, fragment and trailing slashes, so spellings of the same endpoint share
+// their state. Endpoints which can't be parsed are returned unchanged.
+func normalizeEndpoint(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return endpoint
+	}
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Host)
+	if port := u.Port(); (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		host = strings.TrimSuffix(host, ":"+port)
+	}
+	return (&url.URL{Scheme: scheme, Host: host, Path: strings.TrimRight(u.Path, "/")}).String()
+}
+
+// dump returns the config in use with the settings of the flags as YAML, secrets are
+// redacted.
+func (c *Config) dump() ([]byte, error) {
+	return yaml.Marshal(struct {
+		Settings Settings      `yaml:"settings"`
+		Defaults *TargetConfig `yaml:"defaults"`
+		Config   `yaml:",inline"`
+	}{flagSettings, c.defaults, *c})
+}
+
+// readSecret returns the first line of a secret file.
+func readSecret(filename string) (string, error) {
+	content, err := os.ReadFile(filepath.Clean(filename))
+	if err != nil {
+		return "", err
+	}
+	line, _, _ := strings.Cut(string(content), "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Disk metrics
+	disk_info = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "disk_info"),
+		"The model, serial and usage of a physical disk.",
+		[]string{"node", "disk", "devpath", "type", "vendor", "model", "serial", "used"}, nil,
+	)
+	disk_size_bytes = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "disk_size_bytes"),
+		"The size of a physical disk in bytes.",
+		[]string{"node", "disk"}, nil,
+	)
+	disk_wearout_percent = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "disk_wearout_percent"),
+		"The SSD wearout indicator of a physical disk in percent, as shown by PBS.",
+		[]string{"node", "disk"}, nil,
+	)
+	disk_smart_status = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "disk_smart_status"),
+		"Indicates if the SMART health of a physical disk is in the state indicated by the label.",
+		[]string{"node", "disk", "status"}, nil,
+	)
+	disk_smart_attribute_value = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "disk_smart_attribute_value"),
+		"The normalized value of a SMART attribute of a physical disk.",
+		[]string{"node", "disk", "attribute"}, nil,
+	)
+	disk_smart_attribute_raw_value = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "disk_smart_attribute_raw_value"),
+		"The raw value of a SMART attribute of a physical disk.",
+		[]string{"node", "disk", "attribute"}, nil,
+	)
+)
+
+// smartStatuses are the values of the status label of pbs_disk_smart_status.
+var smartStatuses = []string{"passed", "failed", "unknown"}
+
+// smartAttributes are the exported SMART attributes, as named by smartctl for ATA disks and
+// in the NVMe health log.
+var smartAttributes = []string{
+	// ATA
+	"Reallocated_Sector_Ct",
+	"Reported_Uncorrect",
+	"Current_Pending_Sector",
+	"Offline_Uncorrectable",
+	"UDMA_CRC_Error_Count",
+	"Power_On_Hours",
+	"Temperature_Celsius",
+	"Wear_Leveling_Count",
+	"Media_Wearout_Indicator",
+	"Percent_Lifetime_Remain",
+	// NVMe
+	"critical_warning",
+	"available_spare",
+	"percentage_used",
+	"media_errors",
+	"power_on_hours",
+	"temperature",
+}
+
+type DiskResponse struct {
+	Data []Disk `json:"data"`
+}
+
+type Disk struct {
+	Name     string   `json:"name"`
+	DevPath  string   `json:"devpath"`
+	DiskType string   `json:"disk-type"`
+	Vendor   string   `json:"vendor"`
+	Model    string   `json:"model"`
+	Serial   string   `json:"serial"`
+	Size     int64    `json:"size"`
+	Used     string   `json:"used"`
+	Health   string   `json:"health"`
+	Wearout  *float64 `json:"wearout"`
+}
+
+type SmartResponse struct {
+	Data struct {
+		Status     string `json:"status"`
+		Attributes []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+			// missing for NVMe attributes, which only have a raw value
+			Normalized *float64 `json:"normalized"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+func (e *Exporter) getDiskMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	return collectAll(e.nodes(ctx), func(node string) error {
+		return e.getNodeDiskMetric(ctx, node, ch)
+	})
+}
+
+func (e *Exporter) getNodeDiskMetric(ctx context.Context, node string, ch chan<- prometheus.Metric) error {
+	var response DiskResponse
+	err := e.getJSON(ctx, nodeApi+"/"+node+"/disks/list?include-partitions=0", &response)
+	if err != nil {
+		return err
+	}
+
+	return collectAll(response.Data, func(disk Disk) error {
+		e.logger.Debug("Disk", "node", node, "disk", disk.Name, "health", disk.Health)
+
+		ch <- prometheus.MustNewConstMetric(
+			disk_info, prometheus.GaugeValue, 1, node, disk.Name, disk.DevPath, disk.DiskType, disk.Vendor, disk.Model, disk.Serial, disk.Used,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			disk_size_bytes, prometheus.GaugeValue, float64(disk.Size), node, disk.Name,
+		)
+		if disk.Wearout != nil {
+			ch <- prometheus.MustNewConstMetric(
+				disk_wearout_percent, prometheus.GaugeValue, *disk.Wearout, node, disk.Name,
+			)
+		}
+
+		// the health of the disk list is used if the SMART data can't be read, e.g. for USB or
+		// virtual disks
+		var smart SmartResponse
+		err := e.getJSON(ctx, nodeApi+"/"+node+"/disks/smart?disk="+url.QueryEscape(disk.Name), &smart)
+		status := smartStatus(smart.Data.Status)
+		if err != nil {
+			e.logger.Warn("Unable to read SMART data, using the health of the disk list", "node", node, "disk", disk.Name, "err", err)
+			status = smartStatus(disk.Health)
+		}
+
+		// Emit a metric for each possible status with 1/0
+		for _, s := range smartStatuses {
+			val := 0.0
+			if status == s {
+				val = 1.0
+			}
+			ch <- prometheus.MustNewConstMetric(
+				disk_smart_status, prometheus.GaugeValue, val, node, disk.Name, s,
+			)
+		}
+
+		for _, attr := range smart.Data.Attributes {
+			if !slices.Contains(smartAttributes, attr.Name) {
+				continue
+			}
+			if attr.Normalized != nil {
+				ch <- prometheus.MustNewConstMetric(
+					disk_smart_attribute_value, prometheus.GaugeValue, *attr.Normalized, node, disk.Name, attr.Name,
+				)
+			}
+			if raw, ok := smartRawValue(attr.Value); ok {
+				ch <- prometheus.MustNewConstMetric(
+					disk_smart_attribute_raw_value, prometheus.GaugeValue, raw, node, disk.Name, attr.Name,
+				)
+			}
+		}
+
+		return nil
+	})
+}
+
+// smartStatus maps the SMART status of PBS ("passed", "failed", "unknown") to one of
+// smartStatuses.
+func smartStatus(status string) string {
+	switch strings.ToLower(status) {
+	case "passed", "ok":
+		return "passed"
+	case "failed":
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// smartRawValue parses the leading number of a raw SMART value, smartctl appends details to
+// some values, e.g. "35 (Min/Max 20/45)".
+func smartRawValue(value string) (float64, bool) {
+	field, _, _ := strings.Cut(strings.TrimSpace(value), " ")
+	raw, err := strconv.ParseFloat(field, 64)
+	return raw, err == nil
+}
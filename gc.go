@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Garbage collection metrics
+	gc_last_run_timestamp_seconds = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "gc_last_run_timestamp_seconds"),
+		"The end time (unix seconds) of the last garbage collection run of the datastore, the start time on PBS versions which don't report the end time.",
+		[]string{"datastore"}, nil,
+	)
+	gc_next_run_timestamp_seconds = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "gc_next_run_timestamp_seconds"),
+		"The next scheduled garbage collection run (unix seconds) of the datastore.",
+		[]string{"datastore"}, nil,
+	)
+	gc_duration_seconds = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "gc_duration_seconds"),
+		"The duration of the last garbage collection run of the datastore in seconds.",
+		[]string{"datastore"}, nil,
+	)
+	gc_last_run_status = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "gc_last_run_status"),
+		"Indicates if the last garbage collection run of the datastore is in the state indicated by the label.",
+		[]string{"datastore", "status"}, nil,
+	)
+	gc_last_run_info = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "gc_last_run_info"),
+		"The UPID and state of the last garbage collection run of the datastore.",
+		[]string{"datastore", "upid", "state"}, nil,
+	)
+	gc_removed_bytes = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "gc_removed_bytes"),
+		"The bytes removed by the last garbage collection run of the datastore.",
+		[]string{"datastore"}, nil,
+	)
+	gc_removed_chunks = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "gc_removed_chunks"),
+		"The chunks removed by the last garbage collection run of the datastore.",
+		[]string{"datastore"}, nil,
+	)
+	gc_pending_bytes = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "gc_pending_bytes"),
+		"The bytes pending removal after the last garbage collection run of the datastore.",
+		[]string{"datastore"}, nil,
+	)
+	gc_pending_chunks = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "gc_pending_chunks"),
+		"The chunks pending removal after the last garbage collection run of the datastore.",
+		[]string{"datastore"}, nil,
+	)
+	gc_disk_bytes = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "gc_disk_bytes"),
+		"The bytes used on disk by chunks of the datastore.",
+		[]string{"datastore"}, nil,
+	)
+	gc_disk_chunks = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "gc_disk_chunks"),
+		"The number of chunks on disk of the datastore.",
+		[]string{"datastore"}, nil,
+	)
+	gc_deduplication_factor = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "gc_deduplication_factor"),
+		"The deduplication factor (referenced index data bytes / disk bytes) of the datastore.",
+		[]string{"datastore"}, nil,
+	)
+)
+
+// taskStatuses are the values of the status label of task and job state metrics.
+var taskStatuses = []string{"ok", "warning", "error", "unknown"}
+
+type GarbageCollectionStatus struct {
+	Upid           string `json:"upid"`
+	IndexFileCount int64  `json:"index-file-count"`
+	IndexDataBytes int64  `json:"index-data-bytes"`
+	DiskBytes      int64  `json:"disk-bytes"`
+	DiskChunks     int64  `json:"disk-chunks"`
+	RemovedBytes   int64  `json:"removed-bytes"`
+	RemovedChunks  int64  `json:"removed-chunks"`
+	PendingBytes   int64  `json:"pending-bytes"`
+	PendingChunks  int64  `json:"pending-chunks"`
+	RemovedBad     int64  `json:"removed-bad"`
+	StillBad       int64  `json:"still-bad"`
+}
+
+// GarbageCollectionResponse is the job status returned by PBS 3.1 and newer. Older versions
+// only return the embedded GarbageCollectionStatus.
+type GarbageCollectionResponse struct {
+	Data struct {
+		GarbageCollectionStatus
+		LastRunUpid    string `json:"last-run-upid"`
+		LastRunState   string `json:"last-run-state"`
+		LastRunEndtime int64  `json:"last-run-endtime"`
+		NextRun        int64  `json:"next-run"`
+		Duration       int64  `json:"duration"`
+	} `json:"data"`
+}
+
+func (e *Exporter) getGarbageCollectionMetric(ctx context.Context, datastore Datastore, ch chan<- prometheus.Metric) error {
+	var response GarbageCollectionResponse
+	err := e.getJSON(ctx, datastoreApi+"/"+datastore.Store+"/gc", &response)
+	if err != nil {
+		if e.datastoreUnavailable(datastore.Store, err) {
+			return nil
+		}
+		return err
+	}
+
+	// fall back to the gc-status block of the datastore usage if the gc endpoint has no counters
+	status := response.Data.GarbageCollectionStatus
+	if status.Upid == "" && datastore.GCStatus != nil {
+		status = *datastore.GCStatus
+	}
+
+	upid := response.Data.LastRunUpid
+	if upid == "" {
+		upid = status.Upid
+	}
+	lastRun := response.Data.LastRunEndtime
+	if lastRun == 0 {
+		// older PBS versions do not report the end time, use the start time of the task instead
+		lastRun = upidStartTime(upid)
+	}
+	state := response.Data.LastRunState
+
+	e.logger.Debug("Garbage collection", "datastore", datastore.Store, "upid", upid, "state", state)
+
+	ch <- prometheus.MustNewConstMetric(
+		gc_last_run_timestamp_seconds, prometheus.GaugeValue, float64(lastRun), datastore.Store,
+	)
+	if response.Data.NextRun != 0 {
+		ch <- prometheus.MustNewConstMetric(
+			gc_next_run_timestamp_seconds, prometheus.GaugeValue, float64(response.Data.NextRun), datastore.Store,
+		)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		gc_duration_seconds, prometheus.GaugeValue, float64(response.Data.Duration), datastore.Store,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		gc_last_run_info, prometheus.GaugeValue, 1, datastore.Store, upid, state,
+	)
+
+	// Emit a metric for each possible status with 1/0
+	lastStatus := taskStatus(state)
+	for _, s := range taskStatuses {
+		val := 0.0
+		if lastStatus == s {
+			val = 1.0
+		}
+		ch <- prometheus.MustNewConstMetric(
+			gc_last_run_status, prometheus.GaugeValue, val, datastore.Store, s,
+		)
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		gc_removed_bytes, prometheus.GaugeValue, float64(status.RemovedBytes), datastore.Store,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		gc_removed_chunks, prometheus.GaugeValue, float64(status.RemovedChunks), datastore.Store,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		gc_pending_bytes, prometheus.GaugeValue, float64(status.PendingBytes), datastore.Store,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		gc_pending_chunks, prometheus.GaugeValue, float64(status.PendingChunks), datastore.Store,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		gc_disk_bytes, prometheus.GaugeValue, float64(status.DiskBytes), datastore.Store,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		gc_disk_chunks, prometheus.GaugeValue, float64(status.DiskChunks), datastore.Store,
+	)
+	if status.DiskBytes > 0 {
+		ch <- prometheus.MustNewConstMetric(
+			gc_deduplication_factor, prometheus.GaugeValue, float64(status.IndexDataBytes)/float64(status.DiskBytes), datastore.Store,
+		)
+	}
+
+	return nil
+}
+
+// taskStatus maps a PBS task state ("OK", "WARNINGS: <n>", an error message or empty while
+// running) to one of taskStatuses.
+func taskStatus(state string) string {
+	switch {
+	case state == "" || strings.EqualFold(state, "unknown"):
+		return "unknown"
+	case strings.EqualFold(state, "ok"):
+		return "ok"
+	case strings.HasPrefix(strings.ToUpper(state), "WARNINGS"):
+		return "warning"
+	default:
+		return "error"
+	}
+}
+
+// upidStartTime returns the start time (unix seconds) encoded in a PBS UPID
+// (UPID:node:pid:pstart:task_id:starttime:worker_type:worker_id:userid:), or 0 if it can't be parsed.
+func upidStartTime(upid string) int64 {
+	parts := strings.Split(upid, ":")
+	if len(parts) < 6 || parts[0] != "UPID" {
+		return 0
+	}
+	ts, err := strconv.ParseInt(parts[5], 16, 64)
+	if err != nil {
+		return 0
+	}
+	return ts
+}
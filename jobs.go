@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Job metrics
+	job_info = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "job_info"),
+		"The configuration of a scheduled job.",
+		[]string{"job_type", "job_id", "datastore", "namespace", "remote", "remote_store", "schedule"}, nil,
+	)
+	job_last_run_timestamp_seconds = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "job_last_run_timestamp_seconds"),
+		"The end time (unix seconds) of the last run of a scheduled job.",
+		[]string{"job_type", "job_id"}, nil,
+	)
+	job_last_run_status = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "job_last_run_status"),
+		"Indicates if the last run of a scheduled job is in the state indicated by the label.",
+		[]string{"job_type", "job_id", "status"}, nil,
+	)
+	job_next_run_timestamp_seconds = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "job_next_run_timestamp_seconds"),
+		"The next scheduled run (unix seconds) of a scheduled job.",
+		[]string{"job_type", "job_id"}, nil,
+	)
+)
+
+// jobApi maps the job_type label to the API listing the jobs of that type.
+type jobApi struct {
+	jobType string
+	api     string
+}
+
+var jobApis = []jobApi{
+	{"sync", syncJobApi},
+	{"verify", verifyJobApi},
+	{"prune", pruneJobApi},
+}
+
+type JobStatus struct {
+	ID             string `json:"id"`
+	Store          string `json:"store"`
+	Namespace      string `json:"ns"`
+	Remote         string `json:"remote"`
+	RemoteStore    string `json:"remote-store"`
+	Schedule       string `json:"schedule"`
+	NextRun        int64  `json:"next-run"`
+	LastRunState   string `json:"last-run-state"`
+	LastRunUpid    string `json:"last-run-upid"`
+	LastRunEndtime int64  `json:"last-run-endtime"`
+}
+
+type JobResponse struct {
+	Data []JobStatus `json:"data"`
+}
+
+func (e *Exporter) getJobMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	return collectAll(jobApis, func(j jobApi) error {
+		var response JobResponse
+		err := e.getJSON(ctx, j.api, &response)
+		if err != nil {
+			return err
+		}
+
+		for _, job := range response.Data {
+			e.logger.Debug("Job", "job_type", j.jobType, "job_id", job.ID, "datastore", job.Store, "last_run", job.LastRunEndtime, "state", job.LastRunState)
+			setJobMetrics(j.jobType, job, ch)
+		}
+		return nil
+	})
+}
+
+func setJobMetrics(jobType string, job JobStatus, ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(
+		job_info, prometheus.GaugeValue, 1, jobType, job.ID, job.Store, job.Namespace, job.Remote, job.RemoteStore, job.Schedule,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		job_last_run_timestamp_seconds, prometheus.GaugeValue, float64(job.LastRunEndtime), jobType, job.ID,
+	)
+	if job.NextRun != 0 {
+		ch <- prometheus.MustNewConstMetric(
+			job_next_run_timestamp_seconds, prometheus.GaugeValue, float64(job.NextRun), jobType, job.ID,
+		)
+	}
+
+	// Emit a metric for each possible status with 1/0
+	lastStatus := taskStatus(job.LastRunState)
+	for _, s := range taskStatuses {
+		val := 0.0
+		if lastStatus == s {
+			val = 1.0
+		}
+		ch <- prometheus.MustNewConstMetric(
+			job_last_run_status, prometheus.GaugeValue, val, jobType, job.ID, s,
+		)
+	}
+}
@@ -1,30 +1,43 @@
 package main
 
 import (
-	"bufio"
-	"crypto/tls"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/exporter-toolkit/web"
 )
 
 const promNamespace = "pbs"
 const versionApi = "/api2/json/version"
+const remoteApi = "/api2/json/config/remote"
 const datastoreUsageApi = "/api2/json/status/datastore-usage"
 const datastoreApi = "/api2/json/admin/datastore"
 const nodeApi = "/api2/json/nodes"
+const syncJobApi = "/api2/json/admin/sync"
+const verifyJobApi = "/api2/json/admin/verify"
+const pruneJobApi = "/api2/json/admin/prune"
+
+// errDatastoresUnavailable is reported by collectors that need the datastores if the datastore
+// collector failed.
+var errDatastoresUnavailable = errors.New("datastores unavailable")
 
 // These variables are set in build step
 var Version = "v0.0.0-dev.0"
@@ -32,14 +45,23 @@ var Commit = "none"
 var BuildTime = "unknown"
 
 var (
-	tr = &http.Transport{
-		TLSClientConfig: &tls.Config{
-			MinVersion: tls.VersionTLS12,
-		},
-	}
-	client = &http.Client{
-		Transport: tr,
-	}
+	// set from the pbs.legacy-vm-metrics and pbs.snapshot-details flags in main
+	legacyVMMetrics = true
+	snapshotDetails = true
+
+	// set from the pbs.concurrency and pbs.scrape-timeout flags in main
+	maxConcurrency = 4
+	scrapeTimeout  = 10 * time.Second
+
+	// scrapeTimeoutOffset is subtracted from the scrape timeout of Prometheus, so the partial
+	// results of a scrape are returned before Prometheus gives up
+	scrapeTimeoutOffset = 500 * time.Millisecond
+
+	// set from the pbs.poll-interval flag in main, 0 disables polling
+	pollInterval time.Duration
+
+	// exporterRegistry holds the metrics of the exporter itself, served under pbs.telemetry-path
+	exporterRegistry = prometheus.NewRegistry()
 
 	// Flags
 	endpoint = flag.String("pbs.endpoint", "",
@@ -54,25 +76,82 @@ var (
 		"Proxmox Backup Server timeout")
 	insecure = flag.String("pbs.insecure", "false",
 		"Proxmox Backup Server insecure")
+	caFile = flag.String("pbs.ca-file", "",
+		"PEM file with CAs trusted for the Proxmox Backup Server certificate")
+	fingerprint = flag.String("pbs.fingerprint", "",
+		"SHA-256 fingerprint of the Proxmox Backup Server certificate, only this certificate is accepted")
+	certFile = flag.String("pbs.cert-file", "",
+		"Client certificate file for Proxmox Backup Server")
+	keyFile = flag.String("pbs.key-file", "",
+		"Client key file for Proxmox Backup Server")
+	concurrency = flag.String("pbs.concurrency", "4",
+		"Maximum number of concurrent requests to Proxmox Backup Server per scrape")
+	scrapeTimeoutFlag = flag.String("pbs.scrape-timeout", "10s",
+		"Overall deadline for all requests of a scrape, lowered to the scrape timeout sent by Prometheus less 0.5s")
+	pollIntervalFlag = flag.String("pbs.poll-interval", "0s",
+		"Refresh the metrics in the background at this interval and serve them from cache (0s queries PBS on every scrape)")
 	metricsPath = flag.String("pbs.metrics-path", "/metrics",
 		"Path under which to expose metrics")
+	telemetryPath = flag.String("pbs.telemetry-path", "/exporter-metrics",
+		"Path under which to expose the metrics of the exporter itself")
 	listenAddress = flag.String("pbs.listen-address", ":10019",
 		"Address on which to expose metrics")
 	loglevel = flag.String("pbs.loglevel", "info",
-		"Loglevel")
+		"Loglevel (debug, info, warn, error)")
+	logFormat = flag.String("log.format", "logfmt",
+		"Log format (logfmt, json)")
+	legacyVMMetricsFlag = flag.String("pbs.legacy-vm-metrics", "true",
+		"Export the legacy snapshot_vm_* metrics next to the snapshot_group_* metrics")
+	snapshotDetailsFlag = flag.String("pbs.snapshot-details", "true",
+		"List every snapshot for per-snapshot details (verification, size, protection), otherwise only the backup groups are listed")
+	collectorsFlag = flag.String("pbs.collectors", strings.Join(defaultCollectors, ","),
+		"Comma separated list of the enabled collectors")
+	configFile = flag.String("config.file", "",
+		"Path to a YAML file with modules and targets")
+	webConfigFile = flag.String("web.config.file", "",
+		"Path to a web configuration file which can enable TLS or authentication of the exporter")
+	enableLifecycleFlag = flag.String("web.enable-lifecycle", "false",
+		"Enable the reload of the secret files and the config file by POST /-/reload")
+	dumpConfig = flag.Bool("config.dump", false,
+		"Print the configuration with redacted secrets and exit")
 	showVersion = flag.Bool("version", false, "Show version and exit")
 
 	// Metrics
 	up = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "up"),
-		"Was the last query of PBS successful.",
+		"Was the last query of PBS successful (at least one collector succeeded).",
 		nil, nil,
 	)
+	scrape_collector_success = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "scrape", "collector_success"),
+		"Was the last scrape of the collector successful.",
+		[]string{"collector"}, nil,
+	)
+	scrape_collector_duration_seconds = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "scrape", "collector_duration_seconds"),
+		"The duration of the last scrape of the collector in seconds.",
+		[]string{"collector"}, nil,
+	)
 	version = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "version"),
 		"Version of the PBS installation.",
 		[]string{"version", "repoid", "release"}, nil,
 	)
+	remote_info = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "remote_info"),
+		"The host and auth id of a remote.",
+		[]string{"remote", "host", "auth_id"}, nil,
+	)
+	remote_up = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "remote_up"),
+		"Was the last probe of the remote through PBS successful.",
+		[]string{"remote"}, nil,
+	)
+	remote_probe_duration_seconds = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "remote_probe_duration_seconds"),
+		"The duration of the last probe of the remote through PBS in seconds.",
+		[]string{"remote"}, nil,
+	)
 	available = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "available"),
 		"The available bytes of the underlying storage.",
@@ -96,110 +175,184 @@ var (
 	snapshot_vm_count = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "snapshot_vm_count"),
 		"The total number of backups per VM.",
-		[]string{"datastore", "namespace", "vm_id", "vm_name"}, nil,
+		[]string{"datastore", "namespace", "vm_id", "vm_name", "backup_type", "owner"}, nil,
 	)
 	snapshot_vm_last_timestamp = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "snapshot_vm_last_timestamp"),
 		"The timestamp of the last backup of a VM.",
-		[]string{"datastore", "namespace", "vm_id", "vm_name"}, nil,
+		[]string{"datastore", "namespace", "vm_id", "vm_name", "backup_type", "owner"}, nil,
 	)
 	snapshot_vm_last_verify = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "snapshot_vm_last_verify"),
 		"The verify status of the last backup of a VM.",
-		[]string{"datastore", "namespace", "vm_id", "vm_name"}, nil,
+		[]string{"datastore", "namespace", "vm_id", "vm_name", "backup_type", "owner"}, nil,
+	)
+	snapshot_vm_last_size = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "snapshot_vm_last_size"),
+		"The size of the last backup of a VM in bytes.",
+		[]string{"datastore", "namespace", "vm_id", "vm_name", "backup_type", "owner"}, nil,
+	)
+	snapshot_vm_size = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "snapshot_vm_size"),
+		"The total size of all backups of a VM in bytes.",
+		[]string{"datastore", "namespace", "vm_id", "vm_name", "backup_type", "owner"}, nil,
+	)
+	snapshot_vm_protected_count = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "snapshot_vm_protected_count"),
+		"The number of protected backups per VM.",
+		[]string{"datastore", "namespace", "vm_id", "vm_name", "backup_type", "owner"}, nil,
+	)
+	snapshot_group_count = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "snapshot_group_count"),
+		"The total number of backups per backup group.",
+		[]string{"datastore", "namespace", "backup_type", "backup_id", "comment", "owner"}, nil,
+	)
+	snapshot_group_last_timestamp = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "snapshot_group_last_timestamp"),
+		"The timestamp of the last backup of a backup group.",
+		[]string{"datastore", "namespace", "backup_type", "backup_id", "comment", "owner"}, nil,
+	)
+	snapshot_group_last_verify = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "snapshot_group_last_verify"),
+		"The verify status of the last backup of a backup group.",
+		[]string{"datastore", "namespace", "backup_type", "backup_id", "comment", "owner"}, nil,
+	)
+	snapshot_group_last_size = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "snapshot_group_last_size"),
+		"The size of the last backup of a backup group in bytes.",
+		[]string{"datastore", "namespace", "backup_type", "backup_id", "comment", "owner"}, nil,
+	)
+	snapshot_group_size = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "snapshot_group_size"),
+		"The total size of all backups of a backup group in bytes.",
+		[]string{"datastore", "namespace", "backup_type", "backup_id", "comment", "owner"}, nil,
+	)
+	snapshot_group_protected_count = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "snapshot_group_protected_count"),
+		"The number of protected backups per backup group.",
+		[]string{"datastore", "namespace", "backup_type", "backup_id", "comment", "owner"}, nil,
 	)
 	subscription_status = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "host_subscription_status"),
 		"The subscription status of the host.",
-		[]string{"status"}, nil,
+		[]string{"node", "status"}, nil,
 	)
 	subscription_info = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "host_subscription_info"),
 		"The subscription info of the host.",
-		[]string{"productname", "status"}, nil,
+		[]string{"node", "productname", "status"}, nil,
 	)
 	subscription_due_timestamp_seconds = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "host_subscription_due_timestamp_seconds"),
 		"The subscription next due timestamp (unix seconds) of the host.",
-		[]string{"productname"}, nil,
+		[]string{"node", "productname"}, nil,
+	)
+	certificate_not_before_timestamp_seconds = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "certificate_not_before_timestamp_seconds"),
+		"The start of the validity (unix seconds) of a certificate of the host.",
+		[]string{"node", "filename", "issuer", "subject", "fingerprint"}, nil,
+	)
+	certificate_not_after_timestamp_seconds = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "certificate_not_after_timestamp_seconds"),
+		"The end of the validity (unix seconds) of a certificate of the host.",
+		[]string{"node", "filename", "issuer", "subject", "fingerprint"}, nil,
 	)
 	host_cpu_usage = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "host_cpu_usage"),
 		"The CPU usage of the host.",
-		nil, nil,
+		[]string{"node"}, nil,
 	)
 	host_memory_free = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "host_memory_free"),
 		"The free memory of the host.",
-		nil, nil,
+		[]string{"node"}, nil,
 	)
 	host_memory_total = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "host_memory_total"),
 		"The total memory of the host.",
-		nil, nil,
+		[]string{"node"}, nil,
 	)
 	host_memory_used = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "host_memory_used"),
 		"The used memory of the host.",
-		nil, nil,
+		[]string{"node"}, nil,
 	)
 	host_swap_free = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "host_swap_free"),
 		"The free swap of the host.",
-		nil, nil,
+		[]string{"node"}, nil,
 	)
 	host_swap_total = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "host_swap_total"),
 		"The total swap of the host.",
-		nil, nil,
+		[]string{"node"}, nil,
 	)
 	host_swap_used = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "host_swap_used"),
 		"The used swap of the host.",
-		nil, nil,
+		[]string{"node"}, nil,
 	)
 	host_disk_available = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "host_disk_available"),
 		"The available disk of the local root disk in bytes.",
-		nil, nil,
+		[]string{"node"}, nil,
 	)
 	host_disk_total = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "host_disk_total"),
 		"The total disk of the local root disk in bytes.",
-		nil, nil,
+		[]string{"node"}, nil,
 	)
 	host_disk_used = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "host_disk_used"),
 		"The used disk of the local root disk in bytes.",
-		nil, nil,
+		[]string{"node"}, nil,
 	)
 	host_uptime = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "host_uptime"),
 		"The uptime of the host.",
-		nil, nil,
+		[]string{"node"}, nil,
 	)
 	host_io_wait = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "host_io_wait"),
 		"The io wait of the host.",
-		nil, nil,
+		[]string{"node"}, nil,
 	)
 	host_load1 = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "host_load1"),
 		"The load for 1 minute of the host.",
-		nil, nil,
+		[]string{"node"}, nil,
 	)
 	host_load5 = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "host_load5"),
 		"The load for 5 minutes of the host.",
-		nil, nil,
+		[]string{"node"}, nil,
 	)
 	host_load15 = prometheus.NewDesc(
 		prometheus.BuildFQName(promNamespace, "", "host_load15"),
 		"The load for 15 minutes of the host.",
-		nil, nil,
+		[]string{"node"}, nil,
+	)
+	host_updates_pending = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "host_updates_pending"),
+		"The number of pending package updates of the host.",
+		[]string{"node"}, nil,
+	)
+	host_updates_pending_proxmox_backup = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "host_updates_pending_proxmox_backup"),
+		"The number of pending updates of proxmox-backup packages of the host.",
+		[]string{"node"}, nil,
+	)
+	host_service_state = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "host_service_state"),
+		"Indicates if a service of the host is in the state indicated by the label.",
+		[]string{"node", "service", "state"}, nil,
 	)
 )
 
+// serviceStates are the values of the state label of pbs_host_service_state, the systemd
+// active states.
+var serviceStates = []string{"active", "inactive", "failed", "activating", "deactivating", "reloading", "unknown"}
+
 type VersionResponse struct {
 	Data struct {
 		Release string `json:"release"`
@@ -208,38 +361,62 @@ type VersionResponse struct {
 	} `json:"data"`
 }
 
+type RemoteResponse struct {
+	Data []Remote `json:"data"`
+}
+
+type Remote struct {
+	Name   string `json:"name"`
+	Host   string `json:"host"`
+	AuthID string `json:"auth-id"`
+}
+
 type DatastoreResponse struct {
-	Data []struct {
-		Avail     int64  `json:"avail"`
-		Store     string `json:"store"`
-		Total     int64  `json:"total"`
-		Used      int64  `json:"used"`
-		Namespace string `json:"ns"`
-	} `json:"data"`
+	Data []Datastore `json:"data"`
 }
 
 type Datastore struct {
-	Avail     int64  `json:"avail"`
-	Store     string `json:"store"`
-	Total     int64  `json:"total"`
-	Used      int64  `json:"used"`
-	Namespace string `json:"ns"`
+	Avail     int64                    `json:"avail"`
+	Store     string                   `json:"store"`
+	Total     int64                    `json:"total"`
+	Used      int64                    `json:"used"`
+	Namespace string                   `json:"ns"`
+	GCStatus  *GarbageCollectionStatus `json:"gc-status"`
 }
 
 type NamespaceResponse struct {
-	Data []struct {
-		Namespace string `json:"ns"`
-	} `json:"data"`
+	Data []Namespace `json:"data"`
+}
+
+type Namespace struct {
+	Namespace string `json:"ns"`
 }
 
 type SnapshotResponse struct {
+	Data []Snapshot `json:"data"`
+}
+
+type Snapshot struct {
+	BackupID     string `json:"backup-id"`
+	BackupTime   int64  `json:"backup-time"`
+	BackupType   string `json:"backup-type"`
+	VMName       string `json:"comment"`
+	Owner        string `json:"owner"`
+	Size         int64  `json:"size"`
+	Protected    bool   `json:"protected"`
+	Verification struct {
+		State string `json:"state"`
+	} `json:"verification"`
+}
+
+type GroupResponse struct {
 	Data []struct {
-		BackupID     string `json:"backup-id"`
-		BackupTime   int64  `json:"backup-time"`
-		VMName       string `json:"comment"`
-		Verification struct {
-			State string `json:"state"`
-		} `json:"verification"`
+		BackupType  string `json:"backup-type"`
+		BackupID    string `json:"backup-id"`
+		LastBackup  int64  `json:"last-backup"`
+		BackupCount int    `json:"backup-count"`
+		Owner       string `json:"owner"`
+		Comment     string `json:"comment"`
 	} `json:"data"`
 }
 
@@ -267,39 +444,119 @@ type HostResponse struct {
 	} `json:"data"`
 }
 
+type UpdateResponse struct {
+	Data []struct {
+		Package string `json:"Package"`
+	} `json:"data"`
+}
+
+type ServiceResponse struct {
+	Data []struct {
+		Service     string `json:"service"`
+		State       string `json:"state"`
+		ActiveState string `json:"active-state"`
+	} `json:"data"`
+}
+
+type CertificateResponse struct {
+	Data []struct {
+		Filename    string `json:"filename"`
+		Fingerprint string `json:"fingerprint"`
+		Issuer      string `json:"issuer"`
+		Subject     string `json:"subject"`
+		NotBefore   int64  `json:"notbefore"`
+		NotAfter    int64  `json:"notafter"`
+	} `json:"data"`
+}
+
 type Exporter struct {
 	endpoint            string
+	logger              *slog.Logger
 	authorizationHeader string
+	tokenID             string
+	client              *http.Client
+	collectors          []string
+	timeout             time.Duration
+	// sem bounds the number of concurrent requests to the endpoint
+	sem chan struct{}
 }
 
-func ReadSecretFile(secretfilename string) string {
-	file, err := os.Open(filepath.Clean(secretfilename))
-	// flag to check the file format
-	if err != nil {
-		log.Fatal(err)
-	}
-	// Close the file
-	defer func() {
-		if err = file.Close(); err != nil {
-			log.Fatal(err)
-		}
-	}()
-	// Read the first line
-	line := bufio.NewScanner(file)
-	line.Scan()
-	return line.Text()
+// apiError is returned if the endpoint responds with a status code other than 200.
+type apiError struct {
+	endpoint   string
+	statusCode int
+	body       []byte
+}
+
+func (err *apiError) Error() string {
+	return fmt.Sprintf("status code %d returned from endpoint: %s", err.statusCode, err.endpoint)
 }
 
-func NewExporter(endpoint string, username string, apitoken string, apitokenname string) *Exporter {
+func NewExporter(endpoint string, cfg *TargetConfig) *Exporter {
 	return &Exporter{
 		endpoint:            endpoint,
-		authorizationHeader: "PBSAPIToken=" + username + "!" + apitokenname + ":" + apitoken,
+		logger:              slog.Default().With("target", endpoint),
+		authorizationHeader: "PBSAPIToken=" + cfg.Username + "!" + cfg.APITokenName + ":" + string(cfg.APIToken),
+		tokenID:             cfg.Username + "!" + cfg.APITokenName,
+		client:              cfg.client,
+		collectors:          cfg.Collectors,
+		timeout:             scrapeTimeout,
+		sem:                 make(chan struct{}, maxConcurrency),
+	}
+}
+
+// getJSON queries the given API path of the endpoint and decodes the JSON response into v.
+// At most maxConcurrency requests of an exporter are in flight at the same time.
+func (e *Exporter) getJSON(ctx context.Context, path string, v any) error {
+	select {
+	case e.sem <- struct{}{}:
+		defer func() { <-e.sem }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", e.endpoint+path, nil)
+	if err != nil {
+		return err
+	}
+
+	// add Authorization header
+	req.Header.Set("Authorization", e.authorizationHeader)
+
+	// make request and show output
+	start := time.Now()
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
 	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err := resp.Body.Close(); err != nil {
+		e.logger.Warn("Error closing response body", "url", req.URL, "err", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.logger.Debug("Request finished", "url", req.URL, "status", resp.StatusCode, "duration", time.Since(start))
+
+	// check if status code is 200
+	if resp.StatusCode != 200 {
+		return &apiError{endpoint: e.endpoint, statusCode: resp.StatusCode, body: body}
+	}
+
+	// parse json
+	return json.Unmarshal(body, v)
 }
 
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- up
+	ch <- scrape_collector_success
+	ch <- scrape_collector_duration_seconds
 	ch <- version
+	ch <- remote_info
+	ch <- remote_up
+	ch <- remote_probe_duration_seconds
 	ch <- available
 	ch <- size
 	ch <- used
@@ -307,9 +564,40 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- snapshot_vm_count
 	ch <- snapshot_vm_last_timestamp
 	ch <- snapshot_vm_last_verify
+	ch <- snapshot_vm_last_size
+	ch <- snapshot_vm_size
+	ch <- snapshot_vm_protected_count
+	ch <- snapshot_group_count
+	ch <- snapshot_group_last_timestamp
+	ch <- snapshot_group_last_verify
+	ch <- snapshot_group_last_size
+	ch <- snapshot_group_size
+	ch <- snapshot_group_protected_count
+	ch <- gc_last_run_timestamp_seconds
+	ch <- gc_next_run_timestamp_seconds
+	ch <- gc_duration_seconds
+	ch <- gc_last_run_status
+	ch <- gc_last_run_info
+	ch <- gc_removed_bytes
+	ch <- gc_removed_chunks
+	ch <- gc_pending_bytes
+	ch <- gc_pending_chunks
+	ch <- gc_disk_bytes
+	ch <- gc_disk_chunks
+	ch <- gc_deduplication_factor
+	ch <- job_info
+	ch <- job_last_run_timestamp_seconds
+	ch <- job_last_run_status
+	ch <- job_next_run_timestamp_seconds
+	ch <- tasks_total
+	ch <- tasks_running
+	ch <- task_last_end_timestamp_seconds
+	ch <- task_last_duration_seconds
 	ch <- subscription_info
 	ch <- subscription_status
 	ch <- subscription_due_timestamp_seconds
+	ch <- certificate_not_before_timestamp_seconds
+	ch <- certificate_not_after_timestamp_seconds
 	ch <- host_cpu_usage
 	ch <- host_memory_free
 	ch <- host_memory_total
@@ -325,15 +613,65 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- host_load1
 	ch <- host_load5
 	ch <- host_load15
+	ch <- host_updates_pending
+	ch <- host_updates_pending_proxmox_backup
+	ch <- host_service_state
+	ch <- disk_info
+	ch <- disk_size_bytes
+	ch <- disk_wearout_percent
+	ch <- disk_smart_status
+	ch <- disk_smart_attribute_value
+	ch <- disk_smart_attribute_raw_value
+	ch <- zfs_pool_size_bytes
+	ch <- zfs_pool_allocated_bytes
+	ch <- zfs_pool_free_bytes
+	ch <- zfs_pool_fragmentation_percent
+	ch <- zfs_pool_dedup_ratio
+	ch <- zfs_pool_health
+	ch <- zfs_vdev_read_errors
+	ch <- zfs_vdev_write_errors
+	ch <- zfs_vdev_checksum_errors
+	ch <- tape_drive_info
+	ch <- tape_drive_busy
+	ch <- tape_drive_loaded_media
+	ch <- tape_changer_slots
+	ch <- tape_changer_slots_occupied
+	ch <- tape_media_info
+	ch <- tape_media_status
+	ch <- tape_media_expired
+	ch <- tape_media_used_bytes
+	ch <- user_enabled
+	ch <- user_expiry_timestamp_seconds
+	ch <- token_enabled
+	ch <- token_expiry_timestamp_seconds
+	ch <- own_token_expiry_timestamp_seconds
+}
+
+// requestTimeout returns the deadline of a scrape, the timeout sent by Prometheus less
+// scrapeTimeoutOffset, at most pbs.scrape-timeout.
+func requestTimeout(r *http.Request) time.Duration {
+	seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || seconds <= 0 {
+		return scrapeTimeout
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > scrapeTimeoutOffset {
+		timeout -= scrapeTimeoutOffset
+	}
+	return min(timeout, scrapeTimeout)
 }
 
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	err := e.collectFromAPI(ch)
+	// the scrape timeout is the overall deadline for all requests of a scrape
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	err := e.collectFromAPI(ctx, ch)
 	if err != nil {
 		ch <- prometheus.MustNewConstMetric(
 			up, prometheus.GaugeValue, 0,
 		)
-		log.Println(err)
+		e.logger.Error("Scrape failed", "err", err)
 		return
 	}
 	ch <- prometheus.MustNewConstMetric(
@@ -342,128 +680,197 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 
 }
 
-func (e *Exporter) collectFromAPI(ch chan<- prometheus.Metric) error {
-
-	// get version
-	err := e.getVersion(ch)
-	if err != nil {
-		return err
-	}
+// collector is a named part of a scrape which reports its success and duration on its own.
+type collector struct {
+	name    string
+	collect func(ctx context.Context, ch chan<- prometheus.Metric) error
+}
 
-	// get datastores
-	req, err := http.NewRequest("GET", e.endpoint+datastoreUsageApi, nil)
-	if err != nil {
+// collectFromAPI runs the collectors concurrently. A failing collector doesn't stop the others,
+// an error is only returned if all collectors failed.
+func (e *Exporter) collectFromAPI(ctx context.Context, ch chan<- prometheus.Metric) error {
+	// the node collectors share the node names
+	discoverCtx := ctx
+	ctx = context.WithValue(ctx, nodesKey{}, sync.OnceValue(func() []string {
+		return e.discoverNodes(discoverCtx)
+	}))
+
+	var succeeded atomic.Int32
+	run := func(c collector) error {
+		err := e.runCollector(ctx, c, ch)
+		if err == nil {
+			succeeded.Add(1)
+		}
 		return err
 	}
 
-	// add Authorization header
-	req.Header.Set("Authorization", e.authorizationHeader)
+	var wg sync.WaitGroup
+	for _, c := range e.enabled([]collector{
+		{"version", e.getVersion},
+		{"remotes", e.getRemoteMetrics},
+		{"remote_probe", e.getRemoteProbeMetrics},
+		{"node", e.getNodeMetrics},
+		{"updates", e.getNodeUpdateMetrics},
+		{"services", e.getNodeServiceMetrics},
+		{"subscription", e.getNodeSubscriptionMetrics},
+		{"certificates", e.getNodeCertificateMetrics},
+		{"jobs", e.getJobMetrics},
+		{"tasks", e.getTaskMetrics},
+		{"disks", e.getDiskMetrics},
+		{"zfs", e.getZFSMetrics},
+		{"tape", e.getTapeMetrics},
+		{"access", e.getAccessMetrics},
+	}) {
+		wg.Go(func() {
+			_ = run(c)
+		})
+	}
+
+	// the snapshot and gc collectors need the datastores of the datastore collector
+	wg.Go(func() {
+		var datastores []Datastore
+		var datastoresErr error
+		getDatastores := collector{"datastore", func(ctx context.Context, ch chan<- prometheus.Metric) error {
+			var err error
+			datastores, err = e.getDatastoreUsageMetrics(ctx, ch)
+			return err
+		}}
+
+		dependents := e.enabled([]collector{
+			{"snapshot", func(ctx context.Context, ch chan<- prometheus.Metric) error {
+				if datastoresErr != nil {
+					return errDatastoresUnavailable
+				}
+				return collectAll(datastores, func(datastore Datastore) error {
+					return e.getDatastoreMetric(ctx, datastore, ch)
+				})
+			}},
+			{"gc", func(ctx context.Context, ch chan<- prometheus.Metric) error {
+				if datastoresErr != nil {
+					return errDatastoresUnavailable
+				}
+				return collectAll(datastores, func(datastore Datastore) error {
+					return e.getGarbageCollectionMetric(ctx, datastore, ch)
+				})
+			}},
+		})
+
+		switch {
+		case len(e.enabled([]collector{getDatastores})) > 0:
+			datastoresErr = run(getDatastores)
+		case len(dependents) > 0:
+			// the datastore collector is disabled, its metrics are dropped
+			discard := make(chan prometheus.Metric)
+			go func() {
+				for range discard {
+				}
+			}()
+			datastoresErr = getDatastores.collect(ctx, discard)
+			close(discard)
+		}
 
-	// debug
-	if *loglevel == "debug" {
-		log.Printf("DEBUG: Request URL: %s", req.URL)
-		//		log.Printf("DEBUG: Request Header: %s", vmID)
-	}
+		_ = collectAll(dependents, run)
+	})
 
-	// make request and show output
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
+	wg.Wait()
 
-	body, err := io.ReadAll(resp.Body)
-	if err := resp.Body.Close(); err != nil {
-		log.Printf("Error closing response body: %v", err)
-	}
-	if err != nil {
-		return err
-	}
-
-	// check if status code is 200
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("ERROR: Status code %d returned from endpoint: %s", resp.StatusCode, e.endpoint)
+	if succeeded.Load() == 0 {
+		return errors.New("all collectors failed")
 	}
+	return nil
+}
 
-	// debug
-	if *loglevel == "debug" {
-		log.Printf("DEBUG: Status code %d returned from endpoint: %s", resp.StatusCode, e.endpoint)
-		//log.Printf("DEBUG: Response body: %s", string(body))
-	}
+// enabled returns the collectors enabled for the target.
+func (e *Exporter) enabled(collectors []collector) []collector {
+	return slices.DeleteFunc(collectors, func(c collector) bool {
+		return !slices.Contains(e.collectors, c.name)
+	})
+}
 
-	// parse json
-	var response DatastoreResponse
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		return err
-	}
+// runCollector runs a collector and reports its success and duration.
+func (e *Exporter) runCollector(ctx context.Context, c collector, ch chan<- prometheus.Metric) error {
+	start := time.Now()
+	err := c.collect(ctx, ch)
+	duration := time.Since(start)
 
-	// for each datastore collect metrics
-	for _, datastore := range response.Data {
-		err := e.getDatastoreMetric(datastore, ch)
-		if err != nil {
-			return err
-		}
-	}
-
-	// get node metrics
-	err = e.getNodeMetrics(ch)
+	success := 1.0
 	if err != nil {
-		return err
+		success = 0
+		e.logger.Error("Collector failed", "collector", c.name, "duration", duration, "err", err)
+	} else {
+		e.logger.Debug("Collector finished", "collector", c.name, "duration", duration)
 	}
 
-	// get node subscription metrics
-	err = e.getNodeSubscriptionMetrics(ch)
-	if err != nil {
-		return err
-	}
+	ch <- prometheus.MustNewConstMetric(
+		scrape_collector_success, prometheus.GaugeValue, success, c.name,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		scrape_collector_duration_seconds, prometheus.GaugeValue, duration.Seconds(), c.name,
+	)
 
-	return nil
+	return err
 }
 
-func (e *Exporter) getVersion(ch chan<- prometheus.Metric) error {
-	// get version
-	req, err := http.NewRequest("GET", e.endpoint+versionApi, nil)
-	if err != nil {
-		return err
-	}
+// collectAll calls f for each item concurrently and joins the errors. A failing item doesn't
+// stop the others.
+func collectAll[T any](items []T, f func(T) error) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(items))
+	for i, item := range items {
+		wg.Go(func() {
+			errs[i] = f(item)
+		})
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
 
-	// add Authorization header
-	req.Header.Set("Authorization", e.authorizationHeader)
+// nodesKey is the context key of the node names discovered once per scrape.
+type nodesKey struct{}
 
-	// debug
-	if *loglevel == "debug" {
-		log.Printf("DEBUG: Request URL: %s", req.URL)
-		//		log.Printf("DEBUG: Request Header: %s", vmID)
-	}
+// NodeResponse is the node list of the endpoint.
+type NodeResponse struct {
+	Data []struct {
+		Node string `json:"node"`
+	} `json:"data"`
+}
 
-	// make request and show output
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+// nodes returns the node names of the endpoint, they are discovered once per scrape.
+func (e *Exporter) nodes(ctx context.Context) []string {
+	if nodes, ok := ctx.Value(nodesKey{}).(func() []string); ok {
+		return nodes()
 	}
+	return e.discoverNodes(ctx)
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err := resp.Body.Close(); err != nil {
-		log.Printf("Error closing response body: %v", err)
-	}
+// discoverNodes lists the nodes of the endpoint. If the list fails, "localhost" is used, which
+// PBS accepts as name of the local node.
+// see: https://pbs.proxmox.com/docs/api-viewer/index.html#/nodes/{node}
+func (e *Exporter) discoverNodes(ctx context.Context) []string {
+	var response NodeResponse
+	err := e.getJSON(ctx, nodeApi, &response)
 	if err != nil {
-		return err
+		e.logger.Warn("Node discovery failed, using localhost", "err", err)
+		return []string{"localhost"}
 	}
 
-	// debug
-	if *loglevel == "debug" {
-		log.Printf("DEBUG: Status code %d returned from endpoint: %s", resp.StatusCode, e.endpoint)
-		//log.Printf("DEBUG: Response body: %s", string(body))
+	var nodes []string
+	for _, node := range response.Data {
+		if node.Node != "" {
+			nodes = append(nodes, node.Node)
+		}
 	}
-
-	// check if status code is 200
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("ERROR: Status code %d returned from endpoint: %s", resp.StatusCode, e.endpoint)
+	if len(nodes) == 0 {
+		e.logger.Warn("No nodes discovered, using localhost")
+		return []string{"localhost"}
 	}
+	return nodes
+}
 
-	// parse json
+func (e *Exporter) getVersion(ctx context.Context, ch chan<- prometheus.Metric) error {
+	// get version
 	var response VersionResponse
-	err = json.Unmarshal(body, &response)
+	err := e.getJSON(ctx, versionApi, &response)
 	if err != nil {
 		return err
 	}
@@ -475,43 +882,91 @@ func (e *Exporter) getVersion(ch chan<- prometheus.Metric) error {
 	return nil
 }
 
-func (e *Exporter) getNodeSubscriptionMetrics(ch chan<- prometheus.Metric) error {
-	req, err := http.NewRequest("GET", e.endpoint+nodeApi+"/localhost/subscription", nil)
+func (e *Exporter) getRemoteMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	// get remotes
+	var response RemoteResponse
+	err := e.getJSON(ctx, remoteApi, &response)
 	if err != nil {
 		return err
 	}
 
-	// add Authorization header
-	req.Header.Set("Authorization", e.authorizationHeader)
-
-	if *loglevel == "debug" {
-		log.Printf("DEBUG: Request URL: %s", req.URL)
+	for _, remote := range response.Data {
+		ch <- prometheus.MustNewConstMetric(
+			remote_info, prometheus.GaugeValue, 1, remote.Name, remote.Host, remote.AuthID,
+		)
 	}
 
-	resp, err := client.Do(req)
+	return nil
+}
+
+// getRemoteProbeMetrics lets PBS list the datastores of each remote, which fails if the remote
+// is unreachable or its credentials are no longer valid. A failed probe is exported as
+// pbs_remote_up 0 and doesn't fail the collector.
+func (e *Exporter) getRemoteProbeMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	var response RemoteResponse
+	err := e.getJSON(ctx, remoteApi, &response)
 	if err != nil {
 		return err
 	}
-	body, err := io.ReadAll(resp.Body)
-	if err := resp.Body.Close(); err != nil {
-		log.Printf("Error closing response body: %v", err)
-	}
+
+	return collectAll(response.Data, func(remote Remote) error {
+		start := time.Now()
+		var scan struct{}
+		err := e.getJSON(ctx, remoteApi+"/"+url.PathEscape(remote.Name)+"/scan", &scan)
+		duration := time.Since(start)
+
+		val := 1.0
+		if err != nil {
+			e.logger.Warn("Remote probe failed", "remote", remote.Name, "err", err)
+			val = 0.0
+		}
+		ch <- prometheus.MustNewConstMetric(
+			remote_up, prometheus.GaugeValue, val, remote.Name,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			remote_probe_duration_seconds, prometheus.GaugeValue, duration.Seconds(), remote.Name,
+		)
+		return nil
+	})
+}
+
+func (e *Exporter) getDatastoreUsageMetrics(ctx context.Context, ch chan<- prometheus.Metric) ([]Datastore, error) {
+	// get datastores
+	var response DatastoreResponse
+	err := e.getJSON(ctx, datastoreUsageApi, &response)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("ERROR: Status code %d returned from endpoint: %s", resp.StatusCode, e.endpoint)
-	}
+	for _, datastore := range response.Data {
+		e.logger.Debug("Datastore usage", "datastore", datastore.Store, "avail", datastore.Avail, "total", datastore.Total, "used", datastore.Used)
 
-	if *loglevel == "debug" {
-		log.Printf("DEBUG: Status code %d returned from endpoint: %s", resp.StatusCode, e.endpoint)
+		// set datastore metrics
+		ch <- prometheus.MustNewConstMetric(
+			available, prometheus.GaugeValue, float64(datastore.Avail), datastore.Store,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			size, prometheus.GaugeValue, float64(datastore.Total), datastore.Store,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			used, prometheus.GaugeValue, float64(datastore.Used), datastore.Store,
+		)
 	}
 
+	return response.Data, nil
+}
+
+func (e *Exporter) getNodeSubscriptionMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	return collectAll(e.nodes(ctx), func(node string) error {
+		return e.getSubscriptionMetric(ctx, node, ch)
+	})
+}
+
+func (e *Exporter) getSubscriptionMetric(ctx context.Context, node string, ch chan<- prometheus.Metric) error {
 	var raw struct {
 		Data map[string]any `json:"data"`
 	}
-	if err := json.Unmarshal(body, &raw); err != nil {
+	if err := e.getJSON(ctx, nodeApi+"/"+node+"/subscription", &raw); err != nil {
 		return err
 	}
 
@@ -536,11 +991,11 @@ func (e *Exporter) getNodeSubscriptionMetrics(ch chan<- prometheus.Metric) error
 	}
 
 	ch <- prometheus.MustNewConstMetric(
-		subscription_info, prometheus.GaugeValue, 1, productName, statusStr,
+		subscription_info, prometheus.GaugeValue, 1, node, productName, statusStr,
 	)
 
 	ch <- prometheus.MustNewConstMetric(
-		subscription_due_timestamp_seconds, prometheus.GaugeValue, float64(dueTs), productName,
+		subscription_due_timestamp_seconds, prometheus.GaugeValue, float64(dueTs), node, productName,
 	)
 
 	// Emit a metric for each possible status with 1/0
@@ -551,331 +1006,365 @@ func (e *Exporter) getNodeSubscriptionMetrics(ch chan<- prometheus.Metric) error
 			val = 1.0
 		}
 		ch <- prometheus.MustNewConstMetric(
-			subscription_status, prometheus.GaugeValue, val, s,
+			subscription_status, prometheus.GaugeValue, val, node, s,
 		)
 	}
 
 	return nil
 }
 
-func (e *Exporter) getNodeMetrics(ch chan<- prometheus.Metric) error {
-	// NOTE: According to the api documentation, we have to provide the node name (won't work with the node ip),
-	// but it seems to work with any name, so we just use "localhost" here.
-	// see: https://pbs.proxmox.com/docs/api-viewer/index.html#/nodes/{node}
-	req, err := http.NewRequest("GET", e.endpoint+nodeApi+"/localhost/status", nil)
-	if err != nil {
-		return err
-	}
-
-	// add Authorization header
-	req.Header.Set("Authorization", e.authorizationHeader)
-
-	// debug
-	if *loglevel == "debug" {
-		log.Printf("DEBUG: Request URL: %s", req.URL)
-		//log.Printf("DEBUG: Request Header: %s", vmID)
-	}
+func (e *Exporter) getNodeCertificateMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	return collectAll(e.nodes(ctx), func(node string) error {
+		return e.getCertificateMetric(ctx, node, ch)
+	})
+}
 
-	// make request and show output
-	resp, err := client.Do(req)
+func (e *Exporter) getCertificateMetric(ctx context.Context, node string, ch chan<- prometheus.Metric) error {
+	// get the certificates of the proxy
+	var response CertificateResponse
+	err := e.getJSON(ctx, nodeApi+"/"+node+"/certificates/info", &response)
 	if err != nil {
 		return err
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err := resp.Body.Close(); err != nil {
-		log.Printf("Error closing response body: %v", err)
-	}
-	if err != nil {
-		return err
-	}
+	for _, cert := range response.Data {
+		e.logger.Debug("Certificate", "node", node, "filename", cert.Filename, "subject", cert.Subject, "not_after", cert.NotAfter)
 
-	// check if status code is 200
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("ERROR: Status code %d returned from endpoint: %s", resp.StatusCode, e.endpoint)
+		ch <- prometheus.MustNewConstMetric(
+			certificate_not_before_timestamp_seconds, prometheus.GaugeValue, float64(cert.NotBefore), node, cert.Filename, cert.Issuer, cert.Subject, cert.Fingerprint,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			certificate_not_after_timestamp_seconds, prometheus.GaugeValue, float64(cert.NotAfter), node, cert.Filename, cert.Issuer, cert.Subject, cert.Fingerprint,
+		)
 	}
 
-	// debug
-	if *loglevel == "debug" {
-		log.Printf("DEBUG: Status code %d returned from endpoint: %s", resp.StatusCode, e.endpoint)
-		//log.Printf("DEBUG: Response body: %s", string(body))
-	}
+	return nil
+}
 
-	// parse json
+func (e *Exporter) getNodeMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	return collectAll(e.nodes(ctx), func(node string) error {
+		return e.getNodeStatusMetric(ctx, node, ch)
+	})
+}
+
+func (e *Exporter) getNodeStatusMetric(ctx context.Context, node string, ch chan<- prometheus.Metric) error {
 	var response HostResponse
-	err = json.Unmarshal(body, &response)
+	err := e.getJSON(ctx, nodeApi+"/"+node+"/status", &response)
 	if err != nil {
 		return err
 	}
 
 	// set host metrics
 	ch <- prometheus.MustNewConstMetric(
-		host_cpu_usage, prometheus.GaugeValue, float64(response.Data.CPU),
+		host_cpu_usage, prometheus.GaugeValue, float64(response.Data.CPU), node,
 	)
 	ch <- prometheus.MustNewConstMetric(
-		host_memory_free, prometheus.GaugeValue, float64(response.Data.Mem.Free),
+		host_memory_free, prometheus.GaugeValue, float64(response.Data.Mem.Free), node,
 	)
 	ch <- prometheus.MustNewConstMetric(
-		host_memory_total, prometheus.GaugeValue, float64(response.Data.Mem.Total),
+		host_memory_total, prometheus.GaugeValue, float64(response.Data.Mem.Total), node,
 	)
 	ch <- prometheus.MustNewConstMetric(
-		host_memory_used, prometheus.GaugeValue, float64(response.Data.Mem.Used),
+		host_memory_used, prometheus.GaugeValue, float64(response.Data.Mem.Used), node,
 	)
 	ch <- prometheus.MustNewConstMetric(
-		host_swap_free, prometheus.GaugeValue, float64(response.Data.Swap.Free),
+		host_swap_free, prometheus.GaugeValue, float64(response.Data.Swap.Free), node,
 	)
 	ch <- prometheus.MustNewConstMetric(
-		host_swap_total, prometheus.GaugeValue, float64(response.Data.Swap.Total),
+		host_swap_total, prometheus.GaugeValue, float64(response.Data.Swap.Total), node,
 	)
 	ch <- prometheus.MustNewConstMetric(
-		host_swap_used, prometheus.GaugeValue, float64(response.Data.Swap.Used),
+		host_swap_used, prometheus.GaugeValue, float64(response.Data.Swap.Used), node,
 	)
 	ch <- prometheus.MustNewConstMetric(
-		host_disk_available, prometheus.GaugeValue, float64(response.Data.Disk.Avail),
+		host_disk_available, prometheus.GaugeValue, float64(response.Data.Disk.Avail), node,
 	)
 	ch <- prometheus.MustNewConstMetric(
-		host_disk_total, prometheus.GaugeValue, float64(response.Data.Disk.Total),
+		host_disk_total, prometheus.GaugeValue, float64(response.Data.Disk.Total), node,
 	)
 	ch <- prometheus.MustNewConstMetric(
-		host_disk_used, prometheus.GaugeValue, float64(response.Data.Disk.Used),
+		host_disk_used, prometheus.GaugeValue, float64(response.Data.Disk.Used), node,
 	)
 	ch <- prometheus.MustNewConstMetric(
-		host_uptime, prometheus.GaugeValue, float64(response.Data.Uptime),
+		host_uptime, prometheus.GaugeValue, float64(response.Data.Uptime), node,
 	)
 	ch <- prometheus.MustNewConstMetric(
-		host_io_wait, prometheus.GaugeValue, float64(response.Data.Wait),
+		host_io_wait, prometheus.GaugeValue, float64(response.Data.Wait), node,
 	)
 	ch <- prometheus.MustNewConstMetric(
-		host_load1, prometheus.GaugeValue, float64(response.Data.Load[0]),
+		host_load1, prometheus.GaugeValue, float64(response.Data.Load[0]), node,
 	)
 	ch <- prometheus.MustNewConstMetric(
-		host_load5, prometheus.GaugeValue, float64(response.Data.Load[1]),
+		host_load5, prometheus.GaugeValue, float64(response.Data.Load[1]), node,
 	)
 	ch <- prometheus.MustNewConstMetric(
-		host_load15, prometheus.GaugeValue, float64(response.Data.Load[2]),
+		host_load15, prometheus.GaugeValue, float64(response.Data.Load[2]), node,
 	)
 
 	return nil
 }
 
-func (e *Exporter) getDatastoreMetric(datastore Datastore, ch chan<- prometheus.Metric) error {
-	// debug
-	if *loglevel == "debug" {
-		log.Printf("DEBUG: --Store %s", datastore.Store)
-		log.Printf("DEBUG: --Avail %d", datastore.Avail)
-		log.Printf("DEBUG: --Total %d", datastore.Total)
-		log.Printf("DEBUG: --Used %d", datastore.Used)
+func (e *Exporter) getNodeUpdateMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	return collectAll(e.nodes(ctx), func(node string) error {
+		return e.getUpdateMetric(ctx, node, ch)
+	})
+}
+
+func (e *Exporter) getUpdateMetric(ctx context.Context, node string, ch chan<- prometheus.Metric) error {
+	// get the pending updates of the last package index refresh
+	var response UpdateResponse
+	err := e.getJSON(ctx, nodeApi+"/"+node+"/apt/update", &response)
+	if err != nil {
+		return err
 	}
 
-	// set datastore metrics
-	ch <- prometheus.MustNewConstMetric(
-		available, prometheus.GaugeValue, float64(datastore.Avail), datastore.Store,
-	)
+	proxmoxBackup := 0
+	for _, update := range response.Data {
+		if strings.HasPrefix(update.Package, "proxmox-backup") {
+			proxmoxBackup++
+		}
+	}
+
+	e.logger.Debug("Pending updates", "node", node, "updates", len(response.Data), "proxmox_backup", proxmoxBackup)
+
 	ch <- prometheus.MustNewConstMetric(
-		size, prometheus.GaugeValue, float64(datastore.Total), datastore.Store,
+		host_updates_pending, prometheus.GaugeValue, float64(len(response.Data)), node,
 	)
 	ch <- prometheus.MustNewConstMetric(
-		used, prometheus.GaugeValue, float64(datastore.Used), datastore.Store,
+		host_updates_pending_proxmox_backup, prometheus.GaugeValue, float64(proxmoxBackup), node,
 	)
 
-	// get namespaces of datastore
-	req, err := http.NewRequest("GET", e.endpoint+datastoreApi+"/"+datastore.Store+"/namespace", nil)
-	if err != nil {
-		return err
-	}
-
-	// add Authorization header
-	req.Header.Set("Authorization", e.authorizationHeader)
+	return nil
+}
 
-	// debug
-	if *loglevel == "debug" {
-		log.Printf("DEBUG: --Request URL: %s", req.URL)
-		//log.Printf("DEBUG: --Request Header: %s", vmID)
-	}
+func (e *Exporter) getNodeServiceMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	return collectAll(e.nodes(ctx), func(node string) error {
+		return e.getServiceMetric(ctx, node, ch)
+	})
+}
 
-	// make request and show output
-	resp, err := client.Do(req)
+func (e *Exporter) getServiceMetric(ctx context.Context, node string, ch chan<- prometheus.Metric) error {
+	var response ServiceResponse
+	err := e.getJSON(ctx, nodeApi+"/"+node+"/services", &response)
 	if err != nil {
 		return err
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err := resp.Body.Close(); err != nil {
-		log.Printf("Error closing response body: %v", err)
-	}
-	if err != nil {
-		return err
-	}
+	for _, service := range response.Data {
+		state := serviceState(service.ActiveState, service.State)
 
-	// check if status code is 200
-	if resp.StatusCode != 200 {
-		if resp.StatusCode == 400 {
-			// check if datastore is being deleted
-			isBeingDeleted, err := regexp.MatchString("(?i)datastore is being deleted", string(body[:]))
-			if err != nil {
-				return err
-			}
-			if isBeingDeleted {
-				log.Printf("INFO: Datastore: %s is being deleted, Skip scrape datastore metric", datastore.Store)
-				return nil
-			}
-			isMaintenance, err := regexp.MatchString("(?i)offline maintenance mode", string(body[:]))
-			if err != nil {
-				return err
-			}
-			if isMaintenance {
-				log.Printf("INFO: Datastore: %s is in maintenance mode, Skip scrape datastore metric", datastore.Store)
-				return nil
-			}
-			isUnmounted, err := regexp.MatchString("(?i)is not mounted", string(body[:]))
-			if err != nil {
-				return err
-			}
-			if isUnmounted {
-				log.Printf("INFO: Datastore: %s is unmounted, Skip scrape datastore metric", datastore.Store)
-				return nil
+		// Emit a metric for each possible state with 1/0
+		for _, s := range serviceStates {
+			val := 0.0
+			if state == s {
+				val = 1.0
 			}
+			ch <- prometheus.MustNewConstMetric(
+				host_service_state, prometheus.GaugeValue, val, node, service.Service, s,
+			)
 		}
-		return fmt.Errorf("ERROR: --Status code %d returned from endpoint: %s", resp.StatusCode, e.endpoint)
 	}
 
-	// debug
-	if *loglevel == "debug" {
-		log.Printf("DEBUG: --Status code %d returned from endpoint: %s", resp.StatusCode, e.endpoint)
-		//log.Printf("DEBUG: Response body: %s", string(body))
+	return nil
+}
+
+// serviceState maps the systemd state of a service to one of serviceStates. Older versions of
+// PBS only return the sub state, e.g. "running" or "dead".
+func serviceState(activeState string, subState string) string {
+	if slices.Contains(serviceStates, activeState) {
+		return activeState
+	}
+	switch subState {
+	case "running", "exited":
+		return "active"
+	case "dead":
+		return "inactive"
+	case "failed":
+		return "failed"
+	default:
+		return "unknown"
 	}
+}
 
-	// parse json
+func (e *Exporter) getDatastoreMetric(ctx context.Context, datastore Datastore, ch chan<- prometheus.Metric) error {
+	// get namespaces of datastore
 	var response NamespaceResponse
-	err = json.Unmarshal(body, &response)
+	err := e.getJSON(ctx, datastoreApi+"/"+datastore.Store+"/namespace", &response)
 	if err != nil {
+		if e.datastoreUnavailable(datastore.Store, err) {
+			return nil
+		}
 		return err
 	}
 
 	// for each namespace collect metrics
-	for _, namespace := range response.Data {
-		err := e.getNamespaceMetric(datastore.Store, namespace.Namespace, ch)
-		if err != nil {
-			return err
+	return collectAll(response.Data, func(namespace Namespace) error {
+		return e.getNamespaceMetric(ctx, datastore.Store, namespace.Namespace, ch)
+	})
+}
+
+// datastoreUnavailable checks if a request failed because the datastore is being deleted, in
+// maintenance mode or unmounted. Such datastores are skipped.
+func (e *Exporter) datastoreUnavailable(store string, err error) bool {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.statusCode != 400 {
+		return false
+	}
+
+	for _, reason := range []struct {
+		pattern string
+		state   string
+	}{
+		{"(?i)datastore is being deleted", "is being deleted"},
+		{"(?i)offline maintenance mode", "is in maintenance mode"},
+		{"(?i)is not mounted", "is unmounted"},
+	} {
+		if regexp.MustCompile(reason.pattern).Match(apiErr.body) {
+			e.logger.Info("Skip scrape datastore metric", "datastore", store, "reason", reason.state)
+			return true
 		}
 	}
 
-	return nil
+	return false
 }
 
-func (e *Exporter) getNamespaceMetric(datastore string, namespace string, ch chan<- prometheus.Metric) error {
-	// debug
-	if *loglevel == "debug" {
-		log.Printf("DEBUG: ----Namespace %s", namespace)
+func (e *Exporter) getNamespaceMetric(ctx context.Context, datastore string, namespace string, ch chan<- prometheus.Metric) error {
+	e.logger.Debug("Namespace", "datastore", datastore, "namespace", namespace)
+
+	// without snapshot details the backup groups carry everything we need
+	if !snapshotDetails {
+		return e.getNamespaceGroupMetric(ctx, datastore, namespace, ch)
 	}
 
 	// get snapshots of datastore
-	req, err := http.NewRequest("GET", e.endpoint+datastoreApi+"/"+datastore+"/snapshots?ns="+namespace, nil)
+	var response SnapshotResponse
+	err := e.getJSON(ctx, datastoreApi+"/"+datastore+"/snapshots?ns="+namespace, &response)
 	if err != nil {
 		return err
 	}
 
-	// add Authorization header
-	req.Header.Set("Authorization", e.authorizationHeader)
-
-	// debug
-	if *loglevel == "debug" {
-		log.Printf("DEBUG: ----Request URL: %s", req.URL)
-		//log.Printf("DEBUG: ----Request Header: %s", vmID)
-	}
+	// set total snapshot metrics
+	ch <- prometheus.MustNewConstMetric(
+		snapshot_count, prometheus.GaugeValue, float64(len(response.Data)), datastore, namespace,
+	)
 
-	// make request and show output
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
+	// set snapshot metrics per backup group, a VM, container and host with the same
+	// backup-id are different groups
+	groups := make(map[snapshotGroup]*snapshotGroupStats)
+	for i, snapshot := range response.Data {
+		group := snapshotGroup{snapshot.BackupType, snapshot.BackupID}
+		stats, ok := groups[group]
+		if !ok {
+			stats = &snapshotGroupStats{}
+			groups[group] = stats
+		}
 
-	body, err := io.ReadAll(resp.Body)
-	if err := resp.Body.Close(); err != nil {
-		log.Printf("Error closing response body: %v", err)
-	}
-	if err != nil {
-		return err
+		// get comment and owner of the backup group from snapshot
+		stats.comment = snapshot.VMName
+		stats.owner = snapshot.Owner
+		stats.count++
+		stats.size += snapshot.Size
+		if snapshot.Protected {
+			stats.protectedCount++
+		}
+		if stats.last == nil || snapshot.BackupTime > stats.last.BackupTime {
+			stats.last = &response.Data[i]
+			stats.lastTimestamp = snapshot.BackupTime
+		}
 	}
 
-	// check if status code is 200
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("ERROR: ----Status code %d returned from endpoint: %s", resp.StatusCode, e.endpoint)
+	// set snapshot metrics per backup group
+	for group, stats := range groups {
+		setSnapshotGroupMetrics(datastore, namespace, group, stats, ch)
 	}
 
-	// debug
-	if *loglevel == "debug" {
-		log.Printf("DEBUG: ----Status code %d returned from endpoint: %s", resp.StatusCode, e.endpoint)
-		//log.Printf("DEBUG: Response body: %s", string(body))
-	}
+	return nil
+}
 
-	// parse json
-	var response SnapshotResponse
-	err = json.Unmarshal(body, &response)
+func (e *Exporter) getNamespaceGroupMetric(ctx context.Context, datastore string, namespace string, ch chan<- prometheus.Metric) error {
+	// get backup groups of datastore
+	var response GroupResponse
+	err := e.getJSON(ctx, datastoreApi+"/"+datastore+"/groups?ns="+namespace, &response)
 	if err != nil {
 		return err
 	}
 
 	// set total snapshot metrics
+	snapshotCount := 0
+	for _, group := range response.Data {
+		snapshotCount += group.BackupCount
+	}
 	ch <- prometheus.MustNewConstMetric(
-		snapshot_count, prometheus.GaugeValue, float64(len(response.Data)), datastore, namespace,
+		snapshot_count, prometheus.GaugeValue, float64(snapshotCount), datastore, namespace,
 	)
 
-	// set snapshot metrics per vm
-	vmNameMapping := make(map[string]string)
-	vmCount := make(map[string]int)
-	for _, snapshot := range response.Data {
-		// get vm name from snapshot
-		vmID := snapshot.BackupID
-		vmNameMapping[vmID] = snapshot.VMName
-		vmCount[vmID]++
+	// set snapshot metrics per backup group
+	for _, group := range response.Data {
+		setSnapshotGroupMetrics(datastore, namespace, snapshotGroup{group.BackupType, group.BackupID}, &snapshotGroupStats{
+			comment:       group.Comment,
+			owner:         group.Owner,
+			count:         group.BackupCount,
+			lastTimestamp: group.LastBackup,
+		}, ch)
 	}
 
-	// set snapshot metrics per vm
-	for vmID, count := range vmCount {
-		ch <- prometheus.MustNewConstMetric(
-			snapshot_vm_count, prometheus.GaugeValue, float64(count), datastore, namespace, vmID, vmNameMapping[vmID],
-		)
+	return nil
+}
 
-		// find last snapshot with backupID
-		lastTimeStamp, lastVerify, err := findLastSnapshotWithBackupID(response, vmID)
-		if err != nil {
-			return err
-		}
-		lastVerifyBool := 0
-		if lastVerify == "ok" {
-			lastVerifyBool = 1
-		}
-		ch <- prometheus.MustNewConstMetric(
-			snapshot_vm_last_timestamp, prometheus.GaugeValue, float64(lastTimeStamp), datastore, namespace, vmID, vmNameMapping[vmID],
-		)
-		ch <- prometheus.MustNewConstMetric(
-			snapshot_vm_last_verify, prometheus.GaugeValue, float64(lastVerifyBool), datastore, namespace, vmID, vmNameMapping[vmID],
-		)
-	}
+// snapshotGroup identifies a backup group within a namespace.
+type snapshotGroup struct {
+	backupType string
+	backupID   string
+}
 
-	return nil
+// snapshotGroupStats holds the metric values of a backup group. last and the size and
+// protection counters are only set when the snapshots are listed.
+type snapshotGroupStats struct {
+	comment        string
+	owner          string
+	count          int
+	lastTimestamp  int64
+	last           *Snapshot
+	size           int64
+	protectedCount int
 }
 
-func findLastSnapshotWithBackupID(response SnapshotResponse, backupID string) (int64, string, error) {
-	// find biggest value of backupTime of backupID in response array
-	var lastTimeStamp int64
-	var lastVerify string
-	for _, snapshot := range response.Data {
-		if snapshot.BackupID == backupID {
-			if snapshot.BackupTime > lastTimeStamp {
-				lastTimeStamp = snapshot.BackupTime
-				lastVerify = snapshot.Verification.State
-			}
-		}
+func setSnapshotGroupMetrics(datastore string, namespace string, group snapshotGroup, stats *snapshotGroupStats, ch chan<- prometheus.Metric) {
+	metrics := []struct {
+		group *prometheus.Desc
+		vm    *prometheus.Desc
+		value float64
+	}{
+		{snapshot_group_count, snapshot_vm_count, float64(stats.count)},
+		{snapshot_group_last_timestamp, snapshot_vm_last_timestamp, float64(stats.lastTimestamp)},
 	}
 
-	// if lastTimeStamp is still 0, no snapshot was found
-	if lastTimeStamp != 0 {
-		return lastTimeStamp, lastVerify, nil
+	// per-snapshot details are only available if the snapshots were listed
+	if stats.last != nil {
+		lastVerifyBool := 0
+		if stats.last.Verification.State == "ok" {
+			lastVerifyBool = 1
+		}
+		metrics = append(metrics, []struct {
+			group *prometheus.Desc
+			vm    *prometheus.Desc
+			value float64
+		}{
+			{snapshot_group_last_verify, snapshot_vm_last_verify, float64(lastVerifyBool)},
+			{snapshot_group_last_size, snapshot_vm_last_size, float64(stats.last.Size)},
+			{snapshot_group_size, snapshot_vm_size, float64(stats.size)},
+			{snapshot_group_protected_count, snapshot_vm_protected_count, float64(stats.protectedCount)},
+		}...)
+	}
+
+	groupLabels := []string{datastore, namespace, group.backupType, group.backupID, stats.comment, stats.owner}
+	vmLabels := []string{datastore, namespace, group.backupID, stats.comment, group.backupType, stats.owner}
+	for _, m := range metrics {
+		ch <- prometheus.MustNewConstMetric(m.group, prometheus.GaugeValue, m.value, groupLabels...)
+		if legacyVMMetrics {
+			ch <- prometheus.MustNewConstMetric(m.vm, prometheus.GaugeValue, m.value, vmLabels...)
+		}
 	}
-
-	return 0, "", fmt.Errorf("ERROR: No snapshot found with backupID %s", backupID)
 }
 
 func main() {
@@ -886,36 +1375,24 @@ func main() {
 		os.Exit(0)
 	}
 
-	// log build information
-	log.Printf("INFO: Starting PBS Exporter %s, commit %s, built at %s", Version, Commit, BuildTime)
-
 	// if env variable is set, it will overwrite defaults or flags
 	if os.Getenv("PBS_LOGLEVEL") != "" {
 		*loglevel = os.Getenv("PBS_LOGLEVEL")
 	}
+	if os.Getenv("PBS_LOG_FORMAT") != "" {
+		*logFormat = os.Getenv("PBS_LOG_FORMAT")
+	}
 	if os.Getenv("PBS_ENDPOINT") != "" {
 		*endpoint = os.Getenv("PBS_ENDPOINT")
 	}
 	if os.Getenv("PBS_USERNAME") != "" {
 		*username = os.Getenv("PBS_USERNAME")
-	} else {
-		if os.Getenv("PBS_USERNAME_FILE") != "" {
-			*username = ReadSecretFile(os.Getenv("PBS_USERNAME_FILE"))
-		}
 	}
 	if os.Getenv("PBS_API_TOKEN_NAME") != "" {
 		*apitokenname = os.Getenv("PBS_API_TOKEN_NAME")
-	} else {
-		if os.Getenv("PBS_API_TOKEN_NAME_FILE") != "" {
-			*apitokenname = ReadSecretFile(os.Getenv("PBS_API_TOKEN_NAME_FILE"))
-		}
 	}
 	if os.Getenv("PBS_API_TOKEN") != "" {
 		*apitoken = os.Getenv("PBS_API_TOKEN")
-	} else {
-		if os.Getenv("PBS_API_TOKEN_FILE") != "" {
-			*apitoken = ReadSecretFile(os.Getenv("PBS_API_TOKEN_FILE"))
-		}
 	}
 	if os.Getenv("PBS_TIMEOUT") != "" {
 		*timeout = os.Getenv("PBS_TIMEOUT")
@@ -923,91 +1400,287 @@ func main() {
 	if os.Getenv("PBS_INSECURE") != "" {
 		*insecure = os.Getenv("PBS_INSECURE")
 	}
+	if os.Getenv("PBS_CA_FILE") != "" {
+		*caFile = os.Getenv("PBS_CA_FILE")
+	}
+	if os.Getenv("PBS_FINGERPRINT") != "" {
+		*fingerprint = os.Getenv("PBS_FINGERPRINT")
+	}
+	if os.Getenv("PBS_CERT_FILE") != "" {
+		*certFile = os.Getenv("PBS_CERT_FILE")
+	}
+	if os.Getenv("PBS_KEY_FILE") != "" {
+		*keyFile = os.Getenv("PBS_KEY_FILE")
+	}
+	if os.Getenv("PBS_CONCURRENCY") != "" {
+		*concurrency = os.Getenv("PBS_CONCURRENCY")
+	}
+	if os.Getenv("PBS_SCRAPE_TIMEOUT") != "" {
+		*scrapeTimeoutFlag = os.Getenv("PBS_SCRAPE_TIMEOUT")
+	}
+	if os.Getenv("PBS_POLL_INTERVAL") != "" {
+		*pollIntervalFlag = os.Getenv("PBS_POLL_INTERVAL")
+	}
 	if os.Getenv("PBS_METRICS_PATH") != "" {
 		*metricsPath = os.Getenv("PBS_METRICS_PATH")
 	}
+	if os.Getenv("PBS_TELEMETRY_PATH") != "" {
+		*telemetryPath = os.Getenv("PBS_TELEMETRY_PATH")
+	}
 	if os.Getenv("PBS_LISTEN_ADDRESS") != "" {
 		*listenAddress = os.Getenv("PBS_LISTEN_ADDRESS")
 	}
+	if os.Getenv("PBS_LEGACY_VM_METRICS") != "" {
+		*legacyVMMetricsFlag = os.Getenv("PBS_LEGACY_VM_METRICS")
+	}
+	if os.Getenv("PBS_SNAPSHOT_DETAILS") != "" {
+		*snapshotDetailsFlag = os.Getenv("PBS_SNAPSHOT_DETAILS")
+	}
+	if os.Getenv("PBS_COLLECTORS") != "" {
+		*collectorsFlag = os.Getenv("PBS_COLLECTORS")
+	}
+	if os.Getenv("PBS_CONFIG_FILE") != "" {
+		*configFile = os.Getenv("PBS_CONFIG_FILE")
+	}
+	if os.Getenv("PBS_WEB_CONFIG_FILE") != "" {
+		*webConfigFile = os.Getenv("PBS_WEB_CONFIG_FILE")
+	}
+	if os.Getenv("PBS_WEB_ENABLE_LIFECYCLE") != "" {
+		*enableLifecycleFlag = os.Getenv("PBS_WEB_ENABLE_LIFECYCLE")
+	}
+
+	// set logger, also used by the web server
+	logger, err := newLogger(*loglevel, *logFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to create logger: %s\n", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	// log build information
+	logger.Info("Starting PBS Exporter", "version", Version, "commit", Commit, "build_time", BuildTime)
 
 	// convert flags
 	insecureBool, err := strconv.ParseBool(*insecure)
 	if err != nil {
-		log.Fatalf("ERROR: Unable to parse insecure: %s", err)
+		logger.Error("Unable to parse insecure", "err", err)
+		os.Exit(1)
 	}
 
-	// set insecure
-	if insecureBool {
-		tr.TLSClientConfig.InsecureSkipVerify = true
+	// set legacy vm metrics
+	legacyVMMetrics, err = strconv.ParseBool(*legacyVMMetricsFlag)
+	if err != nil {
+		logger.Error("Unable to parse legacy vm metrics", "err", err)
+		os.Exit(1)
+	}
+
+	// set snapshot details
+	snapshotDetails, err = strconv.ParseBool(*snapshotDetailsFlag)
+	if err != nil {
+		logger.Error("Unable to parse snapshot details", "err", err)
+		os.Exit(1)
+	}
+
+	// set lifecycle endpoints
+	enableLifecycle, err := strconv.ParseBool(*enableLifecycleFlag)
+	if err != nil {
+		logger.Error("Unable to parse enable lifecycle", "err", err)
+		os.Exit(1)
 	}
 
 	// set timeout
 	timeoutDuration, err := time.ParseDuration(*timeout)
 	if err != nil {
-		log.Fatalf("ERROR: Unable to parse timeout: %s", err)
+		logger.Error("Unable to parse timeout", "err", err)
+		os.Exit(1)
+	}
+
+	// set concurrency
+	maxConcurrency, err = strconv.Atoi(*concurrency)
+	if err != nil || maxConcurrency < 1 {
+		logger.Error("Unable to parse concurrency", "concurrency", *concurrency)
+		os.Exit(1)
+	}
+
+	// set scrape timeout
+	scrapeTimeout, err = time.ParseDuration(*scrapeTimeoutFlag)
+	if err != nil {
+		logger.Error("Unable to parse scrape timeout", "err", err)
+		os.Exit(1)
+	}
+
+	// set poll interval
+	pollInterval, err = time.ParseDuration(*pollIntervalFlag)
+	if err != nil || pollInterval < 0 {
+		logger.Error("Unable to parse poll interval", "poll_interval", *pollIntervalFlag)
+		os.Exit(1)
+	}
+
+	// set the connection settings of the flags, they are the defaults of the config file
+	flagTarget = TargetConfig{
+		Username:     *username,
+		APITokenName: *apitokenname,
+		APIToken:     Secret(*apitoken),
+		Timeout:      timeoutDuration,
+		TLSConfig: TLSConfig{
+			InsecureSkipVerify: &insecureBool,
+			CAFile:             *caFile,
+			Fingerprint:        *fingerprint,
+			CertFile:           *certFile,
+			KeyFile:            *keyFile,
+		},
+		Collectors: strings.Split(*collectorsFlag, ","),
+	}
+
+	// set the settings of the flags which apply to all targets, for the config dump
+	flagSettings = Settings{
+		Endpoint:        *endpoint,
+		ListenAddress:   *listenAddress,
+		Concurrency:     maxConcurrency,
+		ScrapeTimeout:   scrapeTimeout,
+		PollInterval:    pollInterval,
+		LegacyVMMetrics: legacyVMMetrics,
+		SnapshotDetails: snapshotDetails,
+	}
+
+	// load secret files and config file
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		logger.Error("Unable to load config", "err", err)
+		os.Exit(1)
 	}
-	client.Timeout = timeoutDuration
+	currentConfig.Store(config)
+	setReloadMetrics(true)
 
-	// debug
-	if *loglevel == "debug" {
-		log.Printf("DEBUG: Using connection endpoint: %s", *endpoint)
-		log.Printf("DEBUG: Using connection username: %s", *username)
-		log.Printf("DEBUG: Using connection apitoken: %s", *apitoken)
-		log.Printf("DEBUG: Using connection apitokenname: %s", *apitokenname)
-		log.Printf("DEBUG: Using connection timeout: %s", client.Timeout)
-		log.Printf("DEBUG: Using connection insecure: %t", tr.TLSClientConfig.InsecureSkipVerify)
-		log.Printf("DEBUG: Using metrics path: %s", *metricsPath)
-		log.Printf("DEBUG: Using listen address: %s", *listenAddress)
+	// secrets on the command line are visible to all users in the process list
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "pbs.api.token" {
+			logger.Warn("API token passed on the command line, it is visible in the process list. Use PBS_API_TOKEN, PBS_API_TOKEN_FILE or the config file instead.")
+		}
+	})
+
+	// print config
+	if *dumpConfig {
+		out, err := config.dump()
+		if err != nil {
+			logger.Error("Unable to dump config", "err", err)
+			os.Exit(1)
+		}
+		fmt.Print(string(out))
+		os.Exit(0)
 	}
 
+	logger.Debug("Using configuration",
+		"endpoint", *endpoint,
+		"username", *username,
+		"apitoken", Secret(*apitoken),
+		"apitokenname", *apitokenname,
+		"timeout", timeoutDuration,
+		"insecure", insecureBool,
+		"ca_file", *caFile,
+		"fingerprint", *fingerprint,
+		"cert_file", *certFile,
+		"key_file", *keyFile,
+		"collectors", *collectorsFlag,
+		"config_file", *configFile,
+		"web_config_file", *webConfigFile,
+		"concurrency", maxConcurrency,
+		"scrape_timeout", scrapeTimeout,
+		"poll_interval", pollInterval,
+		"metrics_path", *metricsPath,
+		"telemetry_path", *telemetryPath,
+		"listen_address", *listenAddress,
+		"legacy_vm_metrics", legacyVMMetrics,
+		"snapshot_details", snapshotDetails,
+	)
+
 	if *endpoint != "" {
-		log.Printf("INFO: Using fix connection endpoint: %s", *endpoint)
+		logger.Info("Using fix connection endpoint", "target", *endpoint)
+	}
+	if *configFile != "" {
+		logger.Info("Loaded config file", "file", *configFile, "modules", len(config.Modules), "targets", len(config.Targets))
 	}
-	log.Printf("INFO: Listening on: %s", *listenAddress)
-	log.Printf("INFO: Metrics path: %s", *metricsPath)
+	if pollInterval > 0 {
+		logger.Info("Polling enabled", "interval", pollInterval)
+	}
+	logger.Info("Serving metrics", "listen_address", *listenAddress, "metrics_path", *metricsPath, "telemetry_path", *telemetryPath)
 
 	// start http server
 	http.HandleFunc(*metricsPath, func(w http.ResponseWriter, r *http.Request) {
-		target := ""
+		// select the settings by the "module" or "target" query parameter
+		target, cfg, key, err := currentConfig.Load().resolve(*endpoint, r.URL.Query().Get("module"), r.URL.Query().Get("target"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		// if endpoint was not set as flag or env variable, we try to get it from "target" query parameter
-		if *endpoint != "" {
-			target = *endpoint
+		exporter := NewExporter(target, cfg)
+
+		// in polling mode, serve the metrics of the last background refresh
+		var collector prometheus.Collector = exporter
+		if pollInterval > 0 {
+			collector = getPoller(key, exporter)
 		} else {
-			target = r.URL.Query().Get("target")
-			if target == "" {
-				// if target is not set, we use the default
-				target = "http://localhost:8007"
-			}
+			exporter.timeout = requestTimeout(r)
 		}
 
-		// debug
-		if *loglevel == "debug" {
-			log.Printf("DEBUG: Using connection endpoint %s", strings.ReplaceAll(strings.ReplaceAll(target, "\n", ""), "\r", ""))
+		// a registry per request, so concurrent scrapes of different targets don't collide
+		registry := prometheus.NewRegistry()
+		err = registry.Register(collector)
+		if err != nil {
+			exporter.logger.Error("Unable to register collector", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
 
-		exporter := NewExporter(target, *username, *apitoken, *apitokenname)
+	// reload the secret files and the config file on SIGHUP, and on POST /-/reload if enabled,
+	// because each reload restarts the pollers
+	go reloadOnSignal()
+	if enableLifecycle {
+		http.HandleFunc("/-/reload", handleReload)
+	}
 
-		// catch if register of exporter fails
-		err := prometheus.Register(exporter)
+	// the config in use with redacted secrets
+	http.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		out, err := currentConfig.Load().dump()
 		if err != nil {
-			// if register fails, we log the error and return
-			log.Printf("ERROR: %s", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if _, err := w.Write(out); err != nil {
+			logger.Error("Failed to write response", "err", err)
 		}
-		promhttp.Handler().ServeHTTP(w, r) // Serve the metrics
-		prometheus.Unregister(exporter)    // Clean up after serving
 	})
 
+	// metrics of the exporter itself
+	exporterRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	http.Handle(*telemetryPath, promhttp.InstrumentMetricHandler(
+		exporterRegistry, promhttp.HandlerFor(exporterRegistry, promhttp.HandlerOpts{}),
+	))
+
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// the index page, not a fallback for unknown or disabled paths like /-/reload
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
 		_, err := w.Write([]byte(`<html>
 			<head><title>PBS Exporter</title></head>
 			<body>
 			<h1>Proxmox Backup Server Exporter</h1>
 			<p><a href='` + *metricsPath + `'>Metrics</a></p>
+			<p><a href='` + *telemetryPath + `'>Exporter Metrics</a></p>
+			<p><a href='/config'>Configuration</a></p>
 			</body>
 			</html>`))
 		if err != nil {
-			log.Printf("ERROR: Failed to write response: %s", err)
+			logger.Error("Failed to write response", "err", err)
 		}
 	})
 
@@ -1015,7 +1688,35 @@ func main() {
 		Addr:         *listenAddress,
 		Handler:      nil,
 		ReadTimeout:  time.Second * 10,
-		WriteTimeout: time.Second * 10,
+		WriteTimeout: scrapeTimeout + time.Second*10, // leave room to write the metrics after the scrape deadline
+	}
+	// TLS and basic authentication of the exporter are configured in the web config file
+	listenAddresses := []string{*listenAddress}
+	systemdSocket := false
+	err = web.ListenAndServe(server, &web.FlagConfig{
+		WebListenAddresses: &listenAddresses,
+		WebSystemdSocket:   &systemdSocket,
+		WebConfigFile:      webConfigFile,
+	}, logger)
+	logger.Error("Server stopped", "err", err)
+	os.Exit(1)
+}
+
+// newLogger returns a logger writing to stderr with the given level (debug, info, warn, error)
+// and format (logfmt, json).
+func newLogger(level string, format string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: l}
+
+	switch format {
+	case "logfmt":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, valid formats are logfmt, json", format)
 	}
-	log.Fatal(server.ListenAndServe())
 }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Polling metrics
+	last_refresh_timestamp_seconds = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "last_refresh_timestamp_seconds"),
+		"The time (unix seconds) of the last background refresh of the metrics.",
+		nil, nil,
+	)
+	last_refresh_duration_seconds = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "last_refresh_duration_seconds"),
+		"The duration of the last background refresh of the metrics in seconds.",
+		nil, nil,
+	)
+
+	// pollers per endpoint, started by the first scrape of the endpoint
+	pollersMu sync.Mutex
+	pollers   = make(map[string]*poller)
+)
+
+// pollerIdleIntervals is the number of intervals without a scrape after which a poller stops,
+// but it runs at least pollerMinIdle, so scrape intervals longer than the poll interval keep it.
+const pollerIdleIntervals = 3
+const pollerMinIdle = 5 * time.Minute
+
+// poller refreshes the metrics of an exporter in the background and serves the metrics of
+// the last refresh, so scrapes don't query PBS.
+type poller struct {
+	exporter *Exporter
+	ready    chan struct{}
+	stop     chan struct{}
+
+	mu          sync.RWMutex
+	metrics     []prometheus.Metric
+	lastRefresh time.Time
+	duration    time.Duration
+	lastScrape  time.Time
+}
+
+// getPoller returns the poller of the endpoint, a new poller is started with the given exporter.
+// The returned poller has finished its first refresh.
+func getPoller(endpoint string, exporter *Exporter) *poller {
+	pollersMu.Lock()
+	p, ok := pollers[endpoint]
+	if !ok {
+		p = &poller{
+			exporter:   exporter,
+			ready:      make(chan struct{}),
+			stop:       make(chan struct{}),
+			lastScrape: time.Now(),
+		}
+		pollers[endpoint] = p
+		go p.run(endpoint, pollInterval)
+	}
+	pollersMu.Unlock()
+
+	<-p.ready
+	return p
+}
+
+func (p *poller) run(endpoint string, interval time.Duration) {
+	p.refresh()
+	close(p.ready)
+
+	idle := max(pollerIdleIntervals*interval, pollerMinIdle)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if p.idleSince() > idle {
+				p.evict(endpoint)
+				return
+			}
+			p.refresh()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+func (p *poller) idleSince() time.Duration {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return time.Since(p.lastScrape)
+}
+
+// evict removes the poller of an endpoint which isn't scraped anymore, the next scrape starts
+// a new poller.
+func (p *poller) evict(endpoint string) {
+	pollersMu.Lock()
+	defer pollersMu.Unlock()
+
+	// the poller was already replaced if the config was reloaded
+	if pollers[endpoint] == p {
+		delete(pollers, endpoint)
+	}
+	p.exporter.logger.Debug("Stopped polling of endpoint without scrapes", "idle", p.idleSince())
+}
+
+// stopPollers stops all pollers, the next scrape of an endpoint starts a new poller.
+func stopPollers() {
+	pollersMu.Lock()
+	defer pollersMu.Unlock()
+
+	for key, p := range pollers {
+		close(p.stop)
+		delete(pollers, key)
+	}
+}
+
+func (p *poller) refresh() {
+	start := time.Now()
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		p.exporter.Collect(ch)
+		close(ch)
+	}()
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+
+	duration := time.Since(start)
+
+	p.exporter.logger.Debug("Refreshed metrics", "metrics", len(metrics), "duration", duration)
+
+	p.mu.Lock()
+	p.metrics = metrics
+	p.lastRefresh = start
+	p.duration = duration
+	p.mu.Unlock()
+}
+
+func (p *poller) Describe(ch chan<- *prometheus.Desc) {
+	p.exporter.Describe(ch)
+	ch <- last_refresh_timestamp_seconds
+	ch <- last_refresh_duration_seconds
+}
+
+func (p *poller) Collect(ch chan<- prometheus.Metric) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.lastScrape = time.Now()
+
+	for _, m := range p.metrics {
+		ch <- m
+	}
+	ch <- prometheus.MustNewConstMetric(
+		last_refresh_timestamp_seconds, prometheus.GaugeValue, float64(p.lastRefresh.UnixNano())/1e9,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		last_refresh_duration_seconds, prometheus.GaugeValue, p.duration.Seconds(),
+	)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Reload metrics, served with the metrics of the exporter itself
+	config_last_reload_successful = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: promNamespace,
+		Subsystem: "exporter",
+		Name:      "config_last_reload_successful",
+		Help:      "Was the last reload of the secret files and the config file successful.",
+	})
+	config_last_reload_success_timestamp_seconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: promNamespace,
+		Subsystem: "exporter",
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "The time (unix seconds) of the last successful reload of the secret files and the config file.",
+	})
+
+	// reloadMu serializes reloads of SIGHUP and /-/reload
+	reloadMu sync.Mutex
+)
+
+func init() {
+	exporterRegistry.MustRegister(config_last_reload_successful, config_last_reload_success_timestamp_seconds)
+}
+
+// reloadConfig reads the secret files and the config file again and replaces the current
+// config if they are valid, otherwise the current config is kept.
+func reloadConfig() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		setReloadMetrics(false)
+		return err
+	}
+
+	old := currentConfig.Swap(config)
+	// the pollers still use the settings of the old config
+	stopPollers()
+	if old != nil {
+		old.closeIdleConnections()
+	}
+	setReloadMetrics(true)
+
+	slog.Info("Reloaded config", "modules", len(config.Modules), "targets", len(config.Targets))
+	return nil
+}
+
+func setReloadMetrics(success bool) {
+	if !success {
+		config_last_reload_successful.Set(0)
+		return
+	}
+	config_last_reload_successful.Set(1)
+	config_last_reload_success_timestamp_seconds.SetToCurrentTime()
+}
+
+// reloadOnSignal reloads the config on every SIGHUP.
+func reloadOnSignal() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := reloadConfig(); err != nil {
+			slog.Error("Unable to reload config", "err", err)
+		}
+	}
+}
+
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := reloadConfig(); err != nil {
+		slog.Error("Unable to reload config", "err", err)
+		http.Error(w, "Failed to reload config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
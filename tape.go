@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Tape metrics
+	tape_drive_info = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "tape_drive_info"),
+		"The model and changer of a tape drive.",
+		[]string{"drive", "changer", "vendor", "model", "serial"}, nil,
+	)
+	tape_drive_busy = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "tape_drive_busy"),
+		"Indicates if a tape drive is locked by a task.",
+		[]string{"drive"}, nil,
+	)
+	tape_drive_loaded_media = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "tape_drive_loaded_media"),
+		"The media loaded in a tape drive of a changer, as reported by the changer.",
+		[]string{"drive", "changer", "label"}, nil,
+	)
+	tape_changer_slots = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "tape_changer_slots"),
+		"The number of slots of a tape changer.",
+		[]string{"changer", "kind"}, nil,
+	)
+	tape_changer_slots_occupied = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "tape_changer_slots_occupied"),
+		"The number of slots of a tape changer holding a media.",
+		[]string{"changer", "kind"}, nil,
+	)
+	tape_media_info = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "tape_media_info"),
+		"The pool, media set and location of a tape media.",
+		[]string{"label", "pool", "media_set", "location"}, nil,
+	)
+	tape_media_status = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "tape_media_status"),
+		"Indicates if the status of a tape media is in the state indicated by the label.",
+		[]string{"label", "pool", "status"}, nil,
+	)
+	tape_media_expired = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "tape_media_expired"),
+		"Indicates if the media set of a tape media is expired and the media can be overwritten.",
+		[]string{"label", "pool"}, nil,
+	)
+	tape_media_used_bytes = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "tape_media_used_bytes"),
+		"The bytes written to a tape media.",
+		[]string{"label", "pool"}, nil,
+	)
+)
+
+const tapeDriveApi = "/api2/json/tape/drive"
+const tapeChangerApi = "/api2/json/tape/changer"
+const tapeMediaApi = "/api2/json/tape/media/list"
+const tapeBackupJobApi = "/api2/json/tape/backup"
+
+// tapeMediaStatuses are the values of the status label of pbs_tape_media_status.
+var tapeMediaStatuses = []string{"writable", "full", "retired", "damaged", "unknown"}
+
+type TapeDriveResponse struct {
+	Data []TapeDrive `json:"data"`
+}
+
+// TapeDrive is a configured tape drive, the state is set while a task holds the drive.
+type TapeDrive struct {
+	Name    string `json:"name"`
+	Changer string `json:"changer"`
+	DriveNo int64  `json:"changer-drivenum"`
+	Vendor  string `json:"vendor"`
+	Model   string `json:"model"`
+	Serial  string `json:"serial"`
+	State   string `json:"state"`
+}
+
+type TapeChangerResponse struct {
+	Data []TapeChanger `json:"data"`
+}
+
+type TapeChanger struct {
+	Name string `json:"name"`
+}
+
+// TapeChangerStatusResponse lists the drives, slots and import/export slots of a changer. The
+// label text is empty for a media without barcode and missing for an empty element.
+type TapeChangerStatusResponse struct {
+	Data []struct {
+		Kind      string  `json:"entry-kind"`
+		ID        int64   `json:"entry-id"`
+		LabelText *string `json:"label-text"`
+	} `json:"data"`
+}
+
+type TapeMediaResponse struct {
+	Data []struct {
+		LabelText    string `json:"label-text"`
+		Pool         string `json:"pool"`
+		MediaSetName string `json:"media-set-name"`
+		Location     string `json:"location"`
+		Status       string `json:"status"`
+		Expired      bool   `json:"expired"`
+		BytesUsed    *int64 `json:"bytes-used"`
+	} `json:"data"`
+}
+
+func (e *Exporter) getTapeMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	return errors.Join(
+		e.getTapeDriveMetrics(ctx, ch),
+		e.getTapeMediaMetrics(ctx, ch),
+		e.getTapeBackupJobMetrics(ctx, ch),
+	)
+}
+
+func (e *Exporter) getTapeDriveMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	var drives TapeDriveResponse
+	err := e.getJSON(ctx, tapeDriveApi, &drives)
+	if err != nil {
+		return err
+	}
+
+	// drive names by changer and drive number, to map the drives of the changer status
+	changerDrives := make(map[string]map[int64]string)
+	for _, drive := range drives.Data {
+		e.logger.Debug("Tape drive", "drive", drive.Name, "changer", drive.Changer, "state", drive.State)
+
+		ch <- prometheus.MustNewConstMetric(
+			tape_drive_info, prometheus.GaugeValue, 1, drive.Name, drive.Changer, drive.Vendor, drive.Model, drive.Serial,
+		)
+		busy := 0.0
+		if drive.State != "" {
+			busy = 1.0
+		}
+		ch <- prometheus.MustNewConstMetric(
+			tape_drive_busy, prometheus.GaugeValue, busy, drive.Name,
+		)
+
+		if drive.Changer != "" {
+			if changerDrives[drive.Changer] == nil {
+				changerDrives[drive.Changer] = make(map[int64]string)
+			}
+			changerDrives[drive.Changer][drive.DriveNo] = drive.Name
+		}
+	}
+
+	var changers TapeChangerResponse
+	err = e.getJSON(ctx, tapeChangerApi, &changers)
+	if err != nil {
+		return err
+	}
+
+	return collectAll(changers.Data, func(changer TapeChanger) error {
+		// the cached status doesn't move the robot or wait for it
+		var status TapeChangerStatusResponse
+		err := e.getJSON(ctx, tapeChangerApi+"/"+url.PathEscape(changer.Name)+"/status?cache=true", &status)
+		if err != nil {
+			return err
+		}
+
+		slots := map[string]int{"slot": 0, "import-export": 0}
+		occupied := map[string]int{"slot": 0, "import-export": 0}
+		for _, entry := range status.Data {
+			switch entry.Kind {
+			case "drive":
+				drive, ok := changerDrives[changer.Name][entry.ID]
+				if ok && entry.LabelText != nil {
+					ch <- prometheus.MustNewConstMetric(
+						tape_drive_loaded_media, prometheus.GaugeValue, 1, drive, changer.Name, *entry.LabelText,
+					)
+				}
+			case "slot", "import-export":
+				slots[entry.Kind]++
+				if entry.LabelText != nil {
+					occupied[entry.Kind]++
+				}
+			}
+		}
+
+		for kind, n := range slots {
+			ch <- prometheus.MustNewConstMetric(
+				tape_changer_slots, prometheus.GaugeValue, float64(n), changer.Name, kind,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				tape_changer_slots_occupied, prometheus.GaugeValue, float64(occupied[kind]), changer.Name, kind,
+			)
+		}
+		return nil
+	})
+}
+
+func (e *Exporter) getTapeMediaMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	// the status is not updated from the changers, which would query the hardware
+	var response TapeMediaResponse
+	err := e.getJSON(ctx, tapeMediaApi+"?update-status=false", &response)
+	if err != nil {
+		return err
+	}
+
+	for _, media := range response.Data {
+		ch <- prometheus.MustNewConstMetric(
+			tape_media_info, prometheus.GaugeValue, 1, media.LabelText, media.Pool, media.MediaSetName, media.Location,
+		)
+
+		status := media.Status
+		if !slices.Contains(tapeMediaStatuses, status) {
+			status = "unknown"
+		}
+
+		// Emit a metric for each possible status with 1/0
+		for _, s := range tapeMediaStatuses {
+			val := 0.0
+			if status == s {
+				val = 1.0
+			}
+			ch <- prometheus.MustNewConstMetric(
+				tape_media_status, prometheus.GaugeValue, val, media.LabelText, media.Pool, s,
+			)
+		}
+
+		expired := 0.0
+		if media.Expired {
+			expired = 1.0
+		}
+		ch <- prometheus.MustNewConstMetric(
+			tape_media_expired, prometheus.GaugeValue, expired, media.LabelText, media.Pool,
+		)
+		if media.BytesUsed != nil {
+			ch <- prometheus.MustNewConstMetric(
+				tape_media_used_bytes, prometheus.GaugeValue, float64(*media.BytesUsed), media.LabelText, media.Pool,
+			)
+		}
+	}
+	return nil
+}
+
+// getTapeBackupJobMetrics exports the tape backup jobs as pbs_job_* with job_type "tape".
+func (e *Exporter) getTapeBackupJobMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	var response JobResponse
+	err := e.getJSON(ctx, tapeBackupJobApi, &response)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range response.Data {
+		e.logger.Debug("Job", "job_type", "tape", "job_id", job.ID, "datastore", job.Store, "last_run", job.LastRunEndtime, "state", job.LastRunState)
+		setJobMetrics("tape", job, ch)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// taskLookback is the history read on the first scrape of a target, later scrapes only read
+// tasks since the cursor of the previous scrape.
+const taskLookback = 24 * time.Hour
+
+// taskCursorTTL is the time after which the cursor of a target which isn't scraped anymore is
+// removed, a later scrape of the target starts again with taskLookback.
+const taskCursorTTL = time.Hour
+
+var (
+	// Task metrics
+	tasks_total = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "tasks_total"),
+		"The number of finished tasks per worker type and status.",
+		[]string{"node", "worker_type", "status"}, nil,
+	)
+	tasks_running = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "tasks_running"),
+		"The number of currently running tasks per worker type.",
+		[]string{"node", "worker_type"}, nil,
+	)
+	task_last_end_timestamp_seconds = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "task_last_end_timestamp_seconds"),
+		"The end time (unix seconds) of the last finished task per worker type.",
+		[]string{"node", "worker_type"}, nil,
+	)
+	task_last_duration_seconds = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "task_last_duration_seconds"),
+		"The duration of the last finished task per worker type in seconds.",
+		[]string{"node", "worker_type"}, nil,
+	)
+
+	// task cursors per normalized endpoint and node, kept between scrapes
+	taskCursorsMu sync.Mutex
+	taskCursors   = make(map[string]*taskCursor)
+)
+
+type TaskResponse struct {
+	Data []struct {
+		Upid       string `json:"upid"`
+		WorkerType string `json:"worker_type"`
+		StartTime  int64  `json:"starttime"`
+		EndTime    *int64 `json:"endtime"`
+		Status     string `json:"status"`
+	} `json:"data"`
+}
+
+type taskKey struct {
+	workerType string
+	status     string
+}
+
+// taskCursor accumulates the finished tasks of a node. since is the high-water mark
+// passed to the task list, it never passes the start time of a task that is still running.
+// seen holds the end time of the counted tasks, PBS lists a finished task as long as it ended
+// after since.
+type taskCursor struct {
+	mu           sync.Mutex
+	lastUsed     time.Time
+	since        int64
+	seen         map[string]int64
+	counts       map[taskKey]float64
+	lastEnd      map[string]int64
+	lastDuration map[string]int64
+}
+
+func getTaskCursor(endpoint string, node string) *taskCursor {
+	taskCursorsMu.Lock()
+	defer taskCursorsMu.Unlock()
+
+	now := time.Now()
+	for key, cursor := range taskCursors {
+		if now.Sub(cursor.lastUsed) > taskCursorTTL {
+			delete(taskCursors, key)
+		}
+	}
+
+	key := normalizeEndpoint(endpoint) + "/" + node
+	cursor, ok := taskCursors[key]
+	if !ok {
+		cursor = &taskCursor{
+			since:        now.Add(-taskLookback).Unix(),
+			seen:         make(map[string]int64),
+			counts:       make(map[taskKey]float64),
+			lastEnd:      make(map[string]int64),
+			lastDuration: make(map[string]int64),
+		}
+		taskCursors[key] = cursor
+	}
+	cursor.lastUsed = now
+	return cursor
+}
+
+func (e *Exporter) getTaskMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	return collectAll(e.nodes(ctx), func(node string) error {
+		return e.getNodeTaskMetric(ctx, node, ch)
+	})
+}
+
+func (e *Exporter) getNodeTaskMetric(ctx context.Context, node string, ch chan<- prometheus.Metric) error {
+	cursor := getTaskCursor(e.endpoint, node)
+	cursor.mu.Lock()
+	defer cursor.mu.Unlock()
+
+	// limit=0 lists all tasks since the cursor
+	var response TaskResponse
+	err := e.getJSON(ctx, nodeApi+"/"+node+"/tasks?limit=0&since="+strconv.FormatInt(cursor.since, 10), &response)
+	if err != nil {
+		return err
+	}
+
+	running := make(map[string]int)
+	next := cursor.since
+	oldestRunning := int64(0)
+	for _, task := range response.Data {
+		if task.StartTime > next {
+			next = task.StartTime
+		}
+
+		if task.EndTime == nil {
+			running[task.WorkerType]++
+			if oldestRunning == 0 || task.StartTime < oldestRunning {
+				oldestRunning = task.StartTime
+			}
+			continue
+		}
+
+		// tasks which ended after the cursor are listed again on the next scrape
+		if _, ok := cursor.seen[task.Upid]; ok {
+			continue
+		}
+		cursor.seen[task.Upid] = *task.EndTime
+		cursor.counts[taskKey{task.WorkerType, taskStatus(task.Status)}]++
+		if *task.EndTime >= cursor.lastEnd[task.WorkerType] {
+			cursor.lastEnd[task.WorkerType] = *task.EndTime
+			cursor.lastDuration[task.WorkerType] = *task.EndTime - task.StartTime
+		}
+	}
+
+	// advance the cursor, but keep running tasks in the window
+	if oldestRunning != 0 && oldestRunning < next {
+		next = oldestRunning
+	}
+	cursor.since = next
+	for upid, end := range cursor.seen {
+		if end < cursor.since {
+			delete(cursor.seen, upid)
+		}
+	}
+
+	e.logger.Debug("Tasks", "node", node, "listed", len(response.Data), "cursor", cursor.since)
+
+	for key, count := range cursor.counts {
+		ch <- prometheus.MustNewConstMetric(
+			tasks_total, prometheus.CounterValue, count, node, key.workerType, key.status,
+		)
+	}
+	for workerType, end := range cursor.lastEnd {
+		ch <- prometheus.MustNewConstMetric(
+			task_last_end_timestamp_seconds, prometheus.GaugeValue, float64(end), node, workerType,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			task_last_duration_seconds, prometheus.GaugeValue, float64(cursor.lastDuration[workerType]), node, workerType,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			tasks_running, prometheus.GaugeValue, float64(running[workerType]), node, workerType,
+		)
+	}
+	for workerType, count := range running {
+		// worker types without finished tasks are not covered above
+		if _, ok := cursor.lastEnd[workerType]; !ok {
+			ch <- prometheus.MustNewConstMetric(
+				tasks_running, prometheus.GaugeValue, float64(count), node, workerType,
+			)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+type testTask struct {
+	Upid       string `json:"upid"`
+	WorkerType string `json:"worker_type"`
+	StartTime  int64  `json:"starttime"`
+	EndTime    *int64 `json:"endtime,omitempty"`
+	Status     string `json:"status,omitempty"`
+}
+
+// taskServer serves the task list of node pbs1 like PBS, finished tasks are listed if they
+// ended after since.
+type taskServer struct {
+	mu    sync.Mutex
+	tasks []testTask
+	since int64
+}
+
+func (s *taskServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.URL.Path != nodeApi+"/pbs1/tasks" {
+		http.NotFound(w, r)
+		return
+	}
+	since, err := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.since = since
+
+	data := []testTask{}
+	for _, task := range s.tasks {
+		if task.EndTime == nil || *task.EndTime >= since {
+			data = append(data, task)
+		}
+	}
+	if err := json.NewEncoder(w).Encode(map[string]any{"data": data}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// finish sets the end time and status of a task.
+func (s *taskServer) finish(upid string, end int64, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.tasks {
+		if s.tasks[i].Upid == upid {
+			s.tasks[i].EndTime = &end
+			s.tasks[i].Status = status
+		}
+	}
+}
+
+func (s *taskServer) start(upid string, workerType string, start int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tasks = append(s.tasks, testTask{Upid: upid, WorkerType: workerType, StartTime: start})
+}
+
+// scrapeTasks runs the task collector of node pbs1 and returns the tasks_total counters by
+// worker type and status.
+func scrapeTasks(t *testing.T, e *Exporter) map[taskKey]float64 {
+	t.Helper()
+
+	ch := make(chan prometheus.Metric)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- e.getNodeTaskMetric(context.Background(), "pbs1", ch)
+		close(ch)
+	}()
+
+	counts := make(map[taskKey]float64)
+	for m := range ch {
+		if m.Desc() != tasks_total {
+			continue
+		}
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		labels := make(map[string]string)
+		for _, label := range pb.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		counts[taskKey{labels["worker_type"], labels["status"]}] = pb.GetCounter().GetValue()
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	return counts
+}
+
+func TestTaskCursor(t *testing.T) {
+	server := &taskServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	e := NewExporter(ts.URL, &TargetConfig{client: ts.Client()})
+	base := time.Now().Add(-time.Hour).Unix()
+
+	backupOK := taskKey{"backup", "ok"}
+	verifyOK := taskKey{"verify", "ok"}
+	pruneErr := taskKey{"prune", "error"}
+
+	steps := []struct {
+		name   string
+		update func()
+		counts map[taskKey]float64
+		since  int64
+	}{
+		{
+			name: "overlapping tasks running",
+			update: func() {
+				server.start("UPID:backup", "backup", base)
+				server.start("UPID:verify", "verify", base+1000)
+			},
+			counts: map[taskKey]float64{},
+			since:  0, // the first scrape reads taskLookback
+		},
+		{
+			name:   "verify finished",
+			update: func() { server.finish("UPID:verify", base+1500, "OK") },
+			counts: map[taskKey]float64{verifyOK: 1},
+			since:  base,
+		},
+		{
+			name:   "backup finished",
+			update: func() { server.finish("UPID:backup", base+2000, "OK") },
+			counts: map[taskKey]float64{verifyOK: 1, backupOK: 1},
+			since:  base,
+		},
+		{
+			// the backup started before the cursor, but ended after it and is listed again
+			name:   "cursor passed the start of the backup",
+			update: func() {},
+			counts: map[taskKey]float64{verifyOK: 1, backupOK: 1},
+			since:  base + 1000,
+		},
+		{
+			name:   "no new tasks",
+			update: func() {},
+			counts: map[taskKey]float64{verifyOK: 1, backupOK: 1},
+			since:  base + 1000,
+		},
+		{
+			name: "new task after the backup",
+			update: func() {
+				server.start("UPID:prune", "prune", base+2500)
+				server.finish("UPID:prune", base+2600, "some error")
+			},
+			counts: map[taskKey]float64{verifyOK: 1, backupOK: 1, pruneErr: 1},
+			since:  base + 1000,
+		},
+		{
+			name:   "cursor passed the end of the backup",
+			update: func() {},
+			counts: map[taskKey]float64{verifyOK: 1, backupOK: 1, pruneErr: 1},
+			since:  base + 2500,
+		},
+	}
+	for _, step := range steps {
+		step.update()
+		counts := scrapeTasks(t, e)
+
+		if len(counts) != len(step.counts) {
+			t.Errorf("%s: counts = %v, want %v", step.name, counts, step.counts)
+		}
+		for key, want := range step.counts {
+			if counts[key] != want {
+				t.Errorf("%s: count of %v = %v, want %v", step.name, key, counts[key], want)
+			}
+		}
+		// the since of a scrape is the cursor of the previous scrape
+		if step.since != 0 && server.since != step.since {
+			t.Errorf("%s: since = %d, want %d", step.name, server.since-base, step.since-base)
+		}
+	}
+
+	cursor := getTaskCursor(ts.URL, "pbs1")
+	if _, ok := cursor.seen["UPID:backup"]; ok {
+		t.Errorf("backup which ended before the cursor is still in seen")
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// ZFS metrics
+	zfs_pool_size_bytes = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "zfs_pool_size_bytes"),
+		"The size of a ZFS pool in bytes.",
+		[]string{"node", "pool"}, nil,
+	)
+	zfs_pool_allocated_bytes = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "zfs_pool_allocated_bytes"),
+		"The allocated bytes of a ZFS pool.",
+		[]string{"node", "pool"}, nil,
+	)
+	zfs_pool_free_bytes = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "zfs_pool_free_bytes"),
+		"The free bytes of a ZFS pool.",
+		[]string{"node", "pool"}, nil,
+	)
+	zfs_pool_fragmentation_percent = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "zfs_pool_fragmentation_percent"),
+		"The fragmentation of the free space of a ZFS pool in percent.",
+		[]string{"node", "pool"}, nil,
+	)
+	zfs_pool_dedup_ratio = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "zfs_pool_dedup_ratio"),
+		"The deduplication ratio of a ZFS pool.",
+		[]string{"node", "pool"}, nil,
+	)
+	zfs_pool_health = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "zfs_pool_health"),
+		"Indicates if the health of a ZFS pool is in the state indicated by the label.",
+		[]string{"node", "pool", "state"}, nil,
+	)
+	zfs_vdev_read_errors = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "zfs_vdev_read_errors"),
+		"The read errors of a vdev of a ZFS pool.",
+		[]string{"node", "pool", "vdev"}, nil,
+	)
+	zfs_vdev_write_errors = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "zfs_vdev_write_errors"),
+		"The write errors of a vdev of a ZFS pool.",
+		[]string{"node", "pool", "vdev"}, nil,
+	)
+	zfs_vdev_checksum_errors = prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "", "zfs_vdev_checksum_errors"),
+		"The checksum errors of a vdev of a ZFS pool.",
+		[]string{"node", "pool", "vdev"}, nil,
+	)
+)
+
+// zfsPoolStates are the values of the state label of pbs_zfs_pool_health.
+var zfsPoolStates = []string{"online", "degraded", "faulted", "offline", "removed", "unavail", "suspended", "unknown"}
+
+type ZFSPoolResponse struct {
+	Data []ZFSPool `json:"data"`
+}
+
+type ZFSPool struct {
+	Name   string  `json:"name"`
+	Size   int64   `json:"size"`
+	Alloc  int64   `json:"alloc"`
+	Free   int64   `json:"free"`
+	Frag   float64 `json:"frag"`
+	Dedup  float64 `json:"dedup"`
+	Health string  `json:"health"`
+}
+
+// ZFSPoolDetailResponse is the parsed `zpool status` of a pool. The vdev tree starts with the
+// pool itself as first child.
+type ZFSPoolDetailResponse struct {
+	Data struct {
+		Children []ZFSVdev `json:"children"`
+	} `json:"data"`
+}
+
+type ZFSVdev struct {
+	Name     string    `json:"name"`
+	State    string    `json:"state"`
+	Read     int64     `json:"read"`
+	Write    int64     `json:"write"`
+	Cksum    int64     `json:"cksum"`
+	Children []ZFSVdev `json:"children"`
+}
+
+func (e *Exporter) getZFSMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	return collectAll(e.nodes(ctx), func(node string) error {
+		return e.getNodeZFSMetric(ctx, node, ch)
+	})
+}
+
+func (e *Exporter) getNodeZFSMetric(ctx context.Context, node string, ch chan<- prometheus.Metric) error {
+	var response ZFSPoolResponse
+	err := e.getJSON(ctx, nodeApi+"/"+node+"/disks/zfs", &response)
+	if err != nil {
+		return err
+	}
+
+	return collectAll(response.Data, func(pool ZFSPool) error {
+		e.logger.Debug("ZFS pool", "node", node, "pool", pool.Name, "health", pool.Health)
+
+		ch <- prometheus.MustNewConstMetric(
+			zfs_pool_size_bytes, prometheus.GaugeValue, float64(pool.Size), node, pool.Name,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			zfs_pool_allocated_bytes, prometheus.GaugeValue, float64(pool.Alloc), node, pool.Name,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			zfs_pool_free_bytes, prometheus.GaugeValue, float64(pool.Free), node, pool.Name,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			zfs_pool_fragmentation_percent, prometheus.GaugeValue, pool.Frag, node, pool.Name,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			zfs_pool_dedup_ratio, prometheus.GaugeValue, pool.Dedup, node, pool.Name,
+		)
+
+		// Emit a metric for each possible state with 1/0
+		state := zfsPoolState(pool.Health)
+		for _, s := range zfsPoolStates {
+			val := 0.0
+			if state == s {
+				val = 1.0
+			}
+			ch <- prometheus.MustNewConstMetric(
+				zfs_pool_health, prometheus.GaugeValue, val, node, pool.Name, s,
+			)
+		}
+
+		var detail ZFSPoolDetailResponse
+		err := e.getJSON(ctx, nodeApi+"/"+node+"/disks/zfs/"+url.PathEscape(pool.Name), &detail)
+		if err != nil {
+			return err
+		}
+		seen := make(map[string]bool)
+		for _, vdev := range detail.Data.Children {
+			setZFSVdevMetrics(node, pool.Name, vdev, seen, ch)
+		}
+
+		return nil
+	})
+}
+
+// setZFSVdevMetrics emits the error counters of the vdev and all vdevs below it. Section rows
+// like "logs" or "spares" are listed without counters, names are only exported once.
+func setZFSVdevMetrics(node string, pool string, vdev ZFSVdev, seen map[string]bool, ch chan<- prometheus.Metric) {
+	if vdev.Name != "" && vdev.State != "" && !seen[vdev.Name] {
+		seen[vdev.Name] = true
+		ch <- prometheus.MustNewConstMetric(
+			zfs_vdev_read_errors, prometheus.GaugeValue, float64(vdev.Read), node, pool, vdev.Name,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			zfs_vdev_write_errors, prometheus.GaugeValue, float64(vdev.Write), node, pool, vdev.Name,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			zfs_vdev_checksum_errors, prometheus.GaugeValue, float64(vdev.Cksum), node, pool, vdev.Name,
+		)
+	}
+	for _, child := range vdev.Children {
+		setZFSVdevMetrics(node, pool, child, seen, ch)
+	}
+}
+
+// zfsPoolState maps the health of a pool as shown by `zpool list` to one of zfsPoolStates.
+func zfsPoolState(health string) string {
+	state := strings.ToLower(health)
+	if !slices.Contains(zfsPoolStates, state) {
+		return "unknown"
+	}
+	return state
+}